@@ -0,0 +1,127 @@
+//Package catalog holds everything the bots say, in every language they speak.
+//Each message has a key and one or more variants; if there are several, one
+//is picked at random. Messages may contain parameters like {name}, which are
+//filled in when the message is used.
+//
+//The built-in messages can be overridden from a JSON file, which looks like
+//
+//	{"nl": {"college": "Ik ben op vakantie!", "generic": ["Hm.", "Tja."]}}
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"regexp"
+	"sync"
+)
+
+//The language used when a message does not exist in the requested one
+const DefaultLanguage = "nl"
+
+type Params map[string]interface{}
+
+type Messages map[string][]string
+
+type Catalog struct {
+	lock      sync.RWMutex
+	overrides map[string]Messages
+}
+
+var builtin = map[string]Messages{
+	"nl": nl,
+	"en": en,
+}
+
+var paramRegexp = regexp.MustCompile("\\{([\\w.]+)\\}")
+
+func New() *Catalog {
+	return &Catalog{overrides: make(map[string]Messages)}
+}
+
+//Load replaces the overrides by those in file. On error, the old ones are
+//kept.
+func (c *Catalog) Load(file string) error {
+	jsonBlob, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var raw map[string]map[string]variants
+	if err := json.Unmarshal(jsonBlob, &raw); err != nil {
+		return fmt.Errorf("error parsing %s: %s", file, err)
+	}
+	overrides := make(map[string]Messages)
+	for lang, msgs := range raw {
+		overrides[lang] = make(Messages)
+		for key, v := range msgs {
+			overrides[lang][key] = v
+		}
+	}
+	c.lock.Lock()
+	c.overrides = overrides
+	c.lock.Unlock()
+	return nil
+}
+
+//Pool returns all variants of a message, looking in the overrides first,
+//then the built-in messages of the language and finally those of the default
+//language.
+func (c *Catalog) Pool(lang, key string) []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, l := range []string{lang, DefaultLanguage} {
+		if v := c.overrides[l][key]; len(v) > 0 {
+			return v
+		}
+		if v := builtin[l][key]; len(v) > 0 {
+			return v
+		}
+	}
+	return nil
+}
+
+//Text returns a variant of a message with the parameters filled in. Unknown
+//messages come out as their key, so they are easy to spot.
+func (c *Catalog) Text(lang, key string, params Params) string {
+	pool := c.Pool(lang, key)
+	if len(pool) == 0 {
+		return key
+	}
+	text := pool[0]
+	if len(pool) > 1 {
+		text = pool[rand.Intn(len(pool))]
+	}
+	return Fill(text, params)
+}
+
+//Fill replaces {name} in text by params["name"]. Unknown parameters are left
+//alone.
+func Fill(text string, params Params) string {
+	if len(params) == 0 {
+		return text
+	}
+	return paramRegexp.ReplaceAllStringFunc(text, func(p string) string {
+		if value, ok := params[p[1:len(p)-1]]; ok {
+			return fmt.Sprint(value)
+		}
+		return p
+	})
+}
+
+//In the file, a message can be a single string or a list of variants
+type variants []string
+
+func (v *variants) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*v = variants{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*v = variants(list)
+	return nil
+}
@@ -0,0 +1,44 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestText(test *testing.T) {
+	c := New()
+	if t := c.Text("en", "quote", Params{"name": "Harm", "text": "Hoi"}); t != "My colleague Harm would say: \"Hoi\"" {
+		test.Error("Failed English message with", t)
+	}
+	if t := c.Text("fr", "college", nil); t != nl["college"][0] {
+		test.Error("Failed fallback to the default language with", t)
+	}
+	if t := c.Text("nl", "geen.bericht", nil); t != "geen.bericht" {
+		test.Error("Failed unknown message with", t)
+	}
+}
+
+func TestLoad(test *testing.T) {
+	f, err := ioutil.TempFile("", "messages")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"en": {"college": "On holiday, {sender}!", "generic": ["Hm.", "Hm."]}}`)
+	f.Close()
+
+	c := New()
+	if err := c.Load(f.Name()); err != nil {
+		test.Fatal("Failed loading overrides with", err)
+	}
+	if t := c.Text("en", "college", Params{"sender": "Erik"}); t != "On holiday, Erik!" {
+		test.Error("Failed override with", t)
+	}
+	if t := c.Text("en", "generic", nil); t != "Hm." {
+		test.Error("Failed override of a pool with", t)
+	}
+	if t := c.Text("nl", "college", nil); t != nl["college"][0] {
+		test.Error("Override leaked into another language:", t)
+	}
+}
@@ -0,0 +1,141 @@
+package catalog
+
+var en = Messages{
+	// General
+	"confused": {"I don't understand a word of that."},
+	"usage":    {"usage: {usage}"},
+	"cooldown": {"Easy now, {sender}. Try again in {seconds} seconds."},
+	"quit":     {"I'm off"},
+
+	// Quotes
+	"quote":           {"My colleague {name} would say: \"{text}\""},
+	"quote.confirmed": {"My colleague {name} would indeed say: \"{text}\""},
+	"quote.unknown":   {"I don't remember that colleague."},
+	"quote.nobody":    {"I don't know anyone who would say something that indecent."},
+	"addquote.help":   {"{prefix}addquote Name[, activity,]: Blah"},
+	"addquote.done":   {"If I understand you correctly, {name} would say the following: \"{text}\"."},
+	"undo.nothing":    {"You haven't done anything yet, lazybones."},
+	"undo.done":       {"I know a colleague who might have a tip for you."},
+	"reload.done":     {"I now hold {count} pearls of wisdom from colleagues."},
+	"college":         {"I'm afraid I don't lecture anymore, I'm retired!"},
+	"collage":         {"My colleague {quote.text} would say: \"{quote.name}\""},
+
+	// Lookup services
+	"building.unknown": {"I don't think that building was there before I retired."},
+	"building":         {"{number}. {address}, {name} {map}"},
+	"building.faculty": {"{number}. {address}, {name} ({faculty}) {map}"},
+
+	// P2000
+	"p2000.message":      {"{text}"},
+	"p2000.message.time": {"{time} {text}"},
+	"p2000.none":         {"Nothing found."},
+	"p2000.failed":       {"The scanner is silent; I can't get the alerts right now."},
+	"p2000.alert":        {"Sirens nearby! {time} {text}"},
+
+	// Links
+	"link.page":             {"↳ {title}"},
+	"link.page.description": {"↳ {title}: {description}"},
+	"link.embed":            {"↳ {title}, by {author}"},
+	"link.image":            {"↳ Image: {format}, {width}×{height} pixels"},
+	"link.pdf":              {"↳ PDF: {title}"},
+	"link.pdf.untitled":     {"↳ PDF without a title"},
+
+	// Short links
+	"short.off":        {"I can't make links shorter, that's not switched on."},
+	"short.invalid":    {"That's not a link I can make shorter."},
+	"short.failed":     {"That didn't work: {error}"},
+	"short.private":    {"Ask that in the channel itself."},
+	"short.toomany":    {"You've had enough short links from me already."},
+	"short.full":       {"I can't make any more short links."},
+	"short.nostats":    {"I don't keep those links myself, so I don't know how often they were followed."},
+	"short.unknown":    {"I don't know that short link."},
+	"short.stats":      {"{short} goes to {url}. Made on {created} for {by}, followed {clicks} times, last on {last}."},
+	"short.stats.none": {"{short} goes to {url}. Made on {created} for {by}, never followed yet."},
+
+	// Link log
+	"urls.none":          {"I haven't seen a link like that around here."},
+	"urls.line":          {"{date} {sender}: {url}"},
+	"urls.line.title":    {"{date} {sender}: {title} {url}"},
+	"urls.old":           {"Old news! {sender} posted that on {date}."},
+	"urls.private":       {"Ask that in the channel itself."},
+	"urls.exported":      {"Wrote {count} links to {file}."},
+	"urls.export.failed": {"That didn't work: {error}"},
+
+	// Twitter
+	"twitter.reset":         {"Whales chased away!"},
+	"twitter.failed":        {"Whales are attacking the ship!"},
+	"twitter.crashed":       {"Critical existence failure!"},
+	"twitter.off":           {"Twitter is off here. Have a look at twitter.json."},
+	"twitter.unauthorized":  {"Twitter won't let me in any more; my access was revoked or has expired. An admin has to run twittersetup again, and then say {prefix}fixtwitter."},
+	"twitter.unknown":       {"I don't know that person."},
+	"twitter.following":     {"I'm already following them."},
+	"twitter.not_following": {"I'm not following that person."},
+	"twitter.list.none":     {"I'm not following anyone yet."},
+	"twitter.list.failed":   {"I can't recall who I'm following right now: {error}"},
+	"twitter.link.help": {"Once I have repeated tweets, you can ask for a link to" +
+		" the last tweet with '{prefix}link', to the last tweet by, say, @ineke with" +
+		" '{prefix}link ineke', to her third-last with '{prefix}link ineke 3', or to the last" +
+		" one about coffee with '{prefix}link coffee'."},
+	"twitter.link.ineke": {"Indeed. Too bad she isn't on Twitter, eh.."},
+	"twitter.link.unknown": {"Well, I may be getting on a bit, but I have never" +
+		" heard of that user."},
+	"twitter.link.few":          {"My memory doesn't go back that far."},
+	"twitter.status.connected":  {"I've been listening since {since}."},
+	"twitter.status.waiting":    {"No connection after {attempts} attempts ({error}). Trying again in {seconds} seconds."},
+	"twitter.status.connecting": {"Hold on, I'm connecting."},
+	"twitter.status.stopped":    {"Twitter closed the connection ({error}). Once that's fixed: {prefix}fixtwitter."},
+	"twitter.deleted":           {"@{user} thought better of a tweet and deleted it."},
+	"twitter.filter": {"@{user}: replies {replies}, retweets {retweets}, quotes {quotes}," +
+		" reactions by others {others}."},
+	"twitter.filter.on":      {"on"},
+	"twitter.filter.off":     {"off"},
+	"twitter.filter.include": {"Only with: {words}."},
+	"twitter.filter.exclude": {"Not with: {words}."},
+	"twitter.post.confirm": {"I would send this: \"{text}\" ({length}/{max} characters)." +
+		" Say '{prefix}{command} ja' to send it, or '{prefix}{command} nee' if you've changed your mind."},
+	"twitter.post.toolong":      {"That's {length} characters, only {max} are allowed. Try something shorter."},
+	"twitter.post.unsupported":  {"I can only read along here, not post anything myself."},
+	"twitter.post.wrongcommand": {"Here that's '{prefix}{command}'."},
+	"twitter.post.done":         {"Sent! {link}"},
+	"twitter.post.failed":       {"That didn't work: {error}"},
+	"twitter.post.nothing":      {"I haven't sent anything I can still take back."},
+	"twitter.post.deleted":      {"Taken back. We never said a thing."},
+	"tweet.denied":              {"You may not tweet on my behalf."},
+	"tweet.cancelled":           {"Never mind, then."},
+
+	// Feeds
+	"feeds.none":    {"I'm not reading any feeds yet."},
+	"feeds.entry":   {"{name}: {url}, every {interval} minutes to {channels}"},
+	"feeds.main":    {"the main channel"},
+	"feeds.added":   {"I'll keep an eye on {name} for you."},
+	"feeds.exists":  {"I'm already reading {name}."},
+	"feeds.badurl":  {"That's not an address on the web."},
+	"feeds.failed":  {"I can't make anything of that: {error}"},
+	"feeds.unknown": {"I don't know that feed."},
+	"feeds.removed": {"I'm no longer reading {name}."},
+	"feeds.off":     {"Reading feeds is off here. Have a look at feeds.json."},
+
+	// Replies when someone talks to the bot
+	"generic": {
+		"Have you tried Euclidean geometry?",
+		"How should I know...",
+		"Ask someone else, I'm retired",
+		"I'll just ask Harm.",
+		"What did you say? I was thinking of Ineke.",
+		"There's something to be said against that...",
+		"Learn to spell first.",
+		"Do you really think I'll help you after everything you've said about me?",
+		"This is more something for my colleague Moddemeyer",
+		"Can you explain that?",
+		"That is not satisfactory.",
+		"You can't draw any conclusions from that yet.",
+		"Maybe Jan Salvador knows more about that.",
+		"Do you actually understand the question?",
+		"Maybe you should look at it from the other side.",
+		"That could be more efficient.",
+		"I don't see an Euler path in that.",
+		"I think I understand it, but what does it do?",
+		"You'd better draw a graph for that.",
+		"I think it has something to do with prime numbers.",
+	},
+}
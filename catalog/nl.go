@@ -0,0 +1,141 @@
+package catalog
+
+var nl = Messages{
+	// General
+	"confused": {"Daar snap ik helemaal niets van."},
+	"usage":    {"usage: {usage}"},
+	"cooldown": {"Rustig aan, {sender}. Probeer het over {seconds} seconden nog eens."},
+	"quit":     {"Ik ga al"},
+
+	// Quotes
+	"quote":           {"Mijn collega {name} zou zeggen: \"{text}\""},
+	"quote.confirmed": {"Mijn collega {name} zou inderdaad zeggen: \"{text}\""},
+	"quote.unknown":   {"Die collega herinner ik me niet."},
+	"quote.nobody":    {"Ik ken niemand die zoiets onfatsoenlijks zou zeggen."},
+	"addquote.help":   {"{prefix}addquote Naam[, activiteit,]: Blaat"},
+	"addquote.done":   {"Als ik je goed begrijp, zou {name} het volgende zeggen: \"{text}\"."},
+	"undo.nothing":    {"Je hebt nog helemaal niks gedaan, luiwammes."},
+	"undo.done":       {"Ik ken een collega die nog wel een tip voor je heeft."},
+	"reload.done":     {"Ik bevat nu {count} wijsheden van collega's."},
+	"college":         {"Ik geef helaas geen colleges meer, ik ben met pensioen!"},
+	"collage":         {"Mijn collega {quote.text} zou zeggen: \"{quote.name}\""},
+
+	// Lookup services
+	"building.unknown": {"Dat gebouw stond er voor mijn pensioen nog niet, geloof ik."},
+	"building":         {"{number}. {address}, {name} {map}"},
+	"building.faculty": {"{number}. {address}, {name} ({faculty}) {map}"},
+
+	// P2000
+	"p2000.message":      {"{text}"},
+	"p2000.message.time": {"{time} {text}"},
+	"p2000.none":         {"Niets gevonden."},
+	"p2000.failed":       {"De scanner zwijgt; ik kan de meldingen nu niet ophalen."},
+	"p2000.alert":        {"Sikknel in de buurt! {time} {text}"},
+
+	// Links
+	"link.page":             {"↳ {title}"},
+	"link.page.description": {"↳ {title}: {description}"},
+	"link.embed":            {"↳ {title}, door {author}"},
+	"link.image":            {"↳ Plaatje: {format}, {width}×{height} pixels"},
+	"link.pdf":              {"↳ PDF: {title}"},
+	"link.pdf.untitled":     {"↳ PDF zonder titel"},
+
+	// Short links
+	"short.off":        {"Ik kan geen links korter maken, dat staat niet aan."},
+	"short.invalid":    {"Dat is geen link die ik korter kan maken."},
+	"short.failed":     {"Dat lukte niet: {error}"},
+	"short.private":    {"Vraag dat maar in het kanaal zelf."},
+	"short.toomany":    {"Je hebt al genoeg korte links van me gekregen."},
+	"short.full":       {"Ik kan er geen korte links meer bij maken."},
+	"short.nostats":    {"Die links houd ik niet zelf bij, dus ik weet niet hoe vaak erop geklikt is."},
+	"short.unknown":    {"Die korte link ken ik niet."},
+	"short.stats":      {"{short} gaat naar {url}. Gemaakt op {created} voor {by}, {clicks} keer gevolgd, het laatst op {last}."},
+	"short.stats.none": {"{short} gaat naar {url}. Gemaakt op {created} voor {by}, nog nooit gevolgd."},
+
+	// Link log
+	"urls.none":          {"Zo'n link heb ik hier niet voorbij zien komen."},
+	"urls.line":          {"{date} {sender}: {url}"},
+	"urls.line.title":    {"{date} {sender}: {title} {url}"},
+	"urls.old":           {"Oud nieuws! Al gepost door {sender} op {date}."},
+	"urls.private":       {"Vraag dat maar in het kanaal zelf."},
+	"urls.exported":      {"{count} links geschreven naar {file}."},
+	"urls.export.failed": {"Dat lukte niet: {error}"},
+
+	// Twitter
+	"twitter.reset":         {"Walvissen weggejaagd!"},
+	"twitter.failed":        {"Walvissen vallen het schip aan!"},
+	"twitter.crashed":       {"Critical existence failure!"},
+	"twitter.off":           {"Twitter staat hier uit. Kijk eens naar twitter.json."},
+	"twitter.unauthorized":  {"Twitter laat me er niet meer in; mijn toegang is ingetrokken of verlopen. Een beheerder moet twittersetup opnieuw draaien en daarna {prefix}fixtwitter zeggen."},
+	"twitter.unknown":       {"Die persoon ken ik niet."},
+	"twitter.following":     {"Die volg ik al."},
+	"twitter.not_following": {"Die persoon volg ik niet."},
+	"twitter.list.none":     {"Ik volg nog niemand."},
+	"twitter.list.failed":   {"Ik weet even niet meer wie ik volg: {error}"},
+	"twitter.link.help": {"Als ik tweets heb herhaald, kun je een link opvragen naar" +
+		" de laatste tweet met '{prefix}link', naar de laatste tweet van bijvoorbeeld" +
+		" @ineke met '{prefix}link ineke', naar haar op twee na laatste met '{prefix}link ineke 3'," +
+		" of naar de laatste over koffie met '{prefix}link koffie'."},
+	"twitter.link.ineke": {"Inderdaad. Jammer dat ze niet op Twitter zit hè.."},
+	"twitter.link.unknown": {"Welnu, ik word misschien wat ouder, maar van die gebruiker" +
+		" heb ik nog nooit gehoord."},
+	"twitter.link.few":          {"Zo ver terug gaat mijn geheugen niet."},
+	"twitter.status.connected":  {"Ik luister al sinds {since}."},
+	"twitter.status.waiting":    {"Geen verbinding na {attempts} pogingen ({error}). Volgende poging over {seconds} seconden."},
+	"twitter.status.connecting": {"Even geduld, ik maak verbinding."},
+	"twitter.status.stopped":    {"Twitter heeft de verbinding verbroken ({error}). Na het oplossen: {prefix}fixtwitter."},
+	"twitter.deleted":           {"@{user} heeft een tweet toch maar weer ingetrokken."},
+	"twitter.filter": {"@{user}: antwoorden {replies}, retweets {retweets}, citaten {quotes}," +
+		" reacties van anderen {others}."},
+	"twitter.filter.on":      {"aan"},
+	"twitter.filter.off":     {"uit"},
+	"twitter.filter.include": {"Alleen met: {words}."},
+	"twitter.filter.exclude": {"Niet met: {words}."},
+	"twitter.post.confirm": {"Ik zou dit versturen: \"{text}\" ({length}/{max} tekens)." +
+		" Zeg '{prefix}{command} ja' om het te versturen, of '{prefix}{command} nee' als je je bedenkt."},
+	"twitter.post.toolong":      {"Dat zijn {length} tekens, er mogen er maar {max}. Probeer het wat korter."},
+	"twitter.post.unsupported":  {"Hier kan ik alleen meelezen, niet zelf iets versturen."},
+	"twitter.post.wrongcommand": {"Hier heet dat '{prefix}{command}'."},
+	"twitter.post.done":         {"Verstuurd! {link}"},
+	"twitter.post.failed":       {"Dat lukte niet: {error}"},
+	"twitter.post.nothing":      {"Ik heb niets verstuurd dat ik nog kan intrekken."},
+	"twitter.post.deleted":      {"Ingetrokken. Wij hebben niets gezegd."},
+	"tweet.denied":              {"Jij mag niet namens mij twitteren."},
+	"tweet.cancelled":           {"Dan niet."},
+
+	// Feeds
+	"feeds.none":    {"Ik lees nog geen feeds."},
+	"feeds.entry":   {"{name}: {url}, elke {interval} minuten naar {channels}"},
+	"feeds.main":    {"het hoofdkanaal"},
+	"feeds.added":   {"Ik houd {name} voor je in de gaten."},
+	"feeds.exists":  {"{name} lees ik al."},
+	"feeds.badurl":  {"Dat is geen adres op het web."},
+	"feeds.failed":  {"Daar kan ik niets van maken: {error}"},
+	"feeds.unknown": {"Die feed ken ik niet."},
+	"feeds.removed": {"Ik lees {name} niet meer."},
+	"feeds.off":     {"Feeds lezen staat hier uit. Kijk eens naar feeds.json."},
+
+	// Replies when someone talks to the bot
+	"generic": {
+		"Probeer het eens met euclidische meetkunde.",
+		"Weet ik veel...",
+		"Vraag het een ander, ik ben met pensioen",
+		"Ik zal het even aan Harm vragen.",
+		"Wat zei je? Ik zat even aan Ineke te denken.",
+		"Daar staat wat tegenover...",
+		"Leer eerst eens spellen.",
+		"Denk je echt dat ik je help na alles wat je over me gezegd hebt?",
+		"Dit is meer iets voor mijn collega Moddemeyer",
+		"Kun je dat verklaren?",
+		"Dat is niet bevredigend.",
+		"Daar kun je nog geen conclusie uit trekken.",
+		"Misschien dat Jan Salvador daar meer van weet.",
+		"Begrijp je de vraag eigenlijk wel?",
+		"Misschien moet je het eens van de andere kant bekijken.",
+		"Dat kan efficienter.",
+		"Daar zie ik geen Eulerpad in.",
+		"Ik denk dat ik het begrijp, maar wat doet het?",
+		"Daar kun je beter een graaf bij tekenen.",
+		"Ik denk dat het iets met priemgetallen te maken heeft.",
+	},
+}
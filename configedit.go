@@ -15,6 +15,7 @@ type Config struct {
 	AutoOps   bool
 	Verbose   bool
 	Colors    bool
+	Prefixes  []string
 }
 
 func main() {
@@ -33,6 +34,9 @@ func main() {
 		Verbose:   GetBool("Verbose logging"),
 		Colors:    GetBool("Make tweetbot output gray"),
 	}
+	if prefix := GetString("Command prefix, press enter for '!'"); prefix != "" {
+		conf.Prefixes = []string{prefix}
+	}
 	jsonBlob, err := json.Marshal(conf)
 	if err != nil {
 		fmt.Println("\nCannot make config file, ", err)
@@ -1,59 +1,114 @@
 package eppobot
 
 import (
+	"../catalog"
 	"regexp"
 )
 
 type handler func(*QuoteBot, *IrcMessage, []string)
+type commandHandler func(*QuoteBot, *IrcMessage, *Args)
 
+// An ActionHandler reacts to chat messages. Plain handlers match their Regexp
+// against the whole message. Addressed handlers only see messages starting
+// with the bot's name ("JanEppo: ...", "@JanEppo ..."), and match against the
+// part after it. Command handlers match "!Command ..." with any of the
+// command prefixes configured for the channel, and have the Args their
+// arguments are parsed with.
 type ActionHandler struct {
-	Regexp  *regexp.Regexp
-	Handler handler
+	Regexp    *regexp.Regexp
+	Handler   handler
+	Command   string
+	Args      *ArgSpec
+	Addressed bool
 }
 
 var messageToAction []ActionHandler = []ActionHandler{
 	// Panic handler
-	ActionHandler{regexp.MustCompile("^(\\w+): verdwijn"), forceDisconnect},
+	addressed("^verdwijn", forceDisconnect),
 	// Handlers for QDB-related queries (read)
-	ActionHandler{regexp.MustCompile("^!collega$"), sayQuote},
-	ActionHandler{regexp.MustCompile("^!collega (.+)$"), sayQuote},
-	ActionHandler{regexp.MustCompile("^!wiezei( )(.+)$"), sayQuote},
-	ActionHandler{regexp.MustCompile("^!watzei (.+) over (.+)$"), sayQuote},
-	ActionHandler{regexp.MustCompile("^!college$"), respondCollege},
-	ActionHandler{regexp.MustCompile("^!collage$"), reverseQuote},
-	ActionHandler{regexp.MustCompile("^!janeppo$"), selfQuote},
+	command("collega", "[<naam...>]", sayQuote),
+	command("wiezei", "<tekst...>", sayQuoteByText),
+	command("watzei", "<wie...> over <wat...>", sayQuoteAbout),
+	command("janeppo", "", selfQuote),
 	// (write)
-	ActionHandler{regexp.MustCompile("^!addquote ([^:]+): (.+)$"), addQuote},
-	ActionHandler{regexp.MustCompile("^!undo$"), undoAddQuote},
-	ActionHandler{regexp.MustCompile("^!herlaad$"), reloadDatabase},
+	command("addquote", "<citaat...>", addQuote),
+	command("undo", "", undoAddQuote),
+	command("herlaad", "", reloadDatabase),
 	// Random nonsense
-	ActionHandler{regexp.MustCompile("^!pikk$"), measureAttachment},
-	ActionHandler{regexp.MustCompile("^!ijbepikk$"), measureFrustration},
-	ActionHandler{regexp.MustCompile("^gang"), simpleResponder("GANG!!!")},
-	ActionHandler{regexp.MustCompile("(?i)^la+[sz][eo0]r"), simpleResponder("LAZERS!")},
-	ActionHandler{regexp.MustCompile("^!sl$"), train},
+	command("pikk", "", measureAttachment),
+	command("ijbepikk", "", measureFrustration),
+	command("sl", "", train),
 	// Lookup services
-	ActionHandler{regexp.MustCompile("^!sikknel$"), dispatchP2k},
-	ActionHandler{regexp.MustCompile("^!waaris (.+)$"), findBuilding},
-	ActionHandler{regexp.MustCompile("http"), shortenLink},
+	command("sikknel", "[<n:int>] [<filter...>]", reportP2000),
+	command("waaris", "[--aantal=<n:int>] <gebouw...>", findBuilding),
+	command("short", "<link>", shortCommand),
+	command("shortstats", "<link>", shortStats),
+	command("urls", "[<zoek...>]", listUrls),
+	command("urlexport", "", exportUrls),
 	// Bot controls
-	ActionHandler{regexp.MustCompile("^!raw ([^ ]+) (.+)$"), rawCommand},
-	ActionHandler{regexp.MustCompile("^!ops$"), giveOps},
+	command("raw", "<commando> <argumenten...>", rawCommand),
+	command("ops", "", giveOps),
 	// Twitterbot controls
-	ActionHandler{regexp.MustCompile("^!fixtwitter$"), twitterReset},
-	ActionHandler{regexp.MustCompile("^!follow (.+)$"), twitterAdd},
-	ActionHandler{regexp.MustCompile("^!unfollow (.+)$"), twitterRem},
-	ActionHandler{regexp.MustCompile("^!following$"), twitterList},
-	ActionHandler{regexp.MustCompile("^!link( (.+))?$"), twitterLink},
-	// Generic response
-	ActionHandler{regexp.MustCompile("^(\\w+): "), genericResponse},
+	command("fixtwitter", "", twitterReset),
+	command("follow", "<gebruiker>", twitterAdd),
+	command("unfollow", "<gebruiker>", twitterRem),
+	command("following", "", twitterList),
+	command("link", "[<zoek...>]", twitterLink),
+	command("tweets", "<gebruiker> [<n:int>]", twitterReplay),
+	command("twitterstatus", "", twitterStatus),
+	command("filter", "<gebruiker> [--antwoorden=<aan/uit>] [--retweets=<aan/uit>] [--citaten=<aan/uit>]"+
+		" [--anderen=<aan/uit>] [--wel=<woord>] [--niet=<woord>] [--wis]", twitterFilter),
+	command("tweet", "<tekst...>", postTweet),
+	command("toot", "<tekst...>", postToot),
+	command("deltweet", "", deleteTweet),
+	// Feedbot controls
+	command("feeds", "", feedList),
+	command("addfeed", "<naam> <url> [--minuten=<n:int>]", feedAdd),
+	command("delfeed", "<naam>", feedDel),
+	// Links in anything that isn't a command
+	pattern("http", handleLinks),
+	// The rest is up to the persona, see persona.go
 }
 
-func simpleResponder(s string) handler {
-	return func(b *QuoteBot, in *IrcMessage, submatches []string) {
-		b.Output <- &IrcMessage{
-			Channel: in.Channel,
-			Text:    s,
-		}
+func pattern(re string, h handler) ActionHandler {
+	return ActionHandler{Regexp: regexp.MustCompile(re), Handler: h}
+}
+
+func addressed(re string, h handler) ActionHandler {
+	return ActionHandler{Regexp: regexp.MustCompile(re), Handler: h, Addressed: true}
+}
+
+// Makes a handler for "!name arguments". The arguments are checked against
+// the spec, see ArgSpec; if they don't fit, the sender gets the usage.
+// The handler is passed the whole command and the arguments as submatches.
+func command(name, spec string, h commandHandler) ActionHandler {
+	return specCommand(name, MustParseArgSpec(spec), h)
+}
+
+func specCommand(name string, argSpec *ArgSpec, h commandHandler) ActionHandler {
+	usage := name
+	if argSpec.Usage != "" {
+		usage += " " + argSpec.Usage
+	}
+	return ActionHandler{
+		Command: name,
+		Args:    argSpec,
+		Handler: func(b *QuoteBot, in *IrcMessage, submatches []string) {
+			args, err := argSpec.Parse(submatches[1])
+			if err != nil {
+				b.Output <- &IrcMessage{
+					Channel: in.Channel,
+					Text:    b.text(in.Channel, "confused", nil),
+				}
+				b.Output <- &IrcMessage{
+					Channel: in.Sender,
+					Text: b.text(in.Channel, "usage", catalog.Params{
+						"usage": b.commandPrefixes(in.Channel)[0] + usage,
+					}),
+				}
+				return
+			}
+			h(b, in, args)
+		},
 	}
 }
@@ -0,0 +1,323 @@
+package eppobot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// An ArgSpec describes the arguments a command accepts. It is parsed from a
+// usage string, which doubles as the help text shown when parsing fails:
+//
+//	<naam>           a required argument of one word (or a "quoted string")
+//	<naam...>        a required argument that takes the rest of the line, or
+//	                 everything up to the next literal
+//	<n:int>          a required argument that must be a number
+//	[<naam>]         an optional argument, may be combined with the above
+//	[--aantal=<n:int>]
+//	                 an optional flag with a value, written as --aantal=3
+//	[--alles]        an optional flag without a value
+//	over             a literal word that must appear as-is
+type ArgSpec struct {
+	Usage  string
+	params []argParam
+	flags  map[string]argParam
+}
+
+type argParam struct {
+	Name     string
+	Literal  string
+	Type     string
+	Optional bool
+	Rest     bool
+	HasValue bool
+}
+
+// Args holds the arguments of a command after they have been checked against
+// an ArgSpec. Optional arguments and flags that were not given are absent.
+type Args struct {
+	values map[string]string
+	flags  map[string]string
+}
+
+// A UsageError means the arguments did not fit the ArgSpec.
+type UsageError struct {
+	Usage  string
+	Reason string
+}
+
+func (e *UsageError) Error() string {
+	return fmt.Sprintf("usage: %s (%s)", e.Usage, e.Reason)
+}
+
+// An argToken is a word from the input, remembering where it came from so
+// that greedy arguments can return the original text.
+type argToken struct {
+	Text       string
+	Start, End int
+	Quoted     bool
+	Index      int
+}
+
+func ParseArgSpec(usage string) (*ArgSpec, error) {
+	spec := &ArgSpec{Usage: usage, flags: make(map[string]argParam)}
+	for _, word := range strings.Fields(usage) {
+		p := argParam{}
+		if strings.HasPrefix(word, "[") && strings.HasSuffix(word, "]") {
+			p.Optional = true
+			word = word[1 : len(word)-1]
+		}
+		switch {
+		case strings.HasPrefix(word, "--"):
+			if !p.Optional {
+				return nil, fmt.Errorf("flag %s must be optional", word)
+			}
+			name := word[2:]
+			if i := strings.Index(name, "="); i >= 0 {
+				value, err := parseArgName(name[i+1:])
+				if err != nil {
+					return nil, err
+				}
+				p.HasValue = true
+				p.Type = value.Type
+				name = name[:i]
+			}
+			p.Name = name
+			spec.flags[name] = p
+			continue
+		case strings.HasPrefix(word, "<"):
+			named, err := parseArgName(word)
+			if err != nil {
+				return nil, err
+			}
+			named.Optional = p.Optional
+			p = named
+		default:
+			if p.Optional {
+				return nil, fmt.Errorf("literal %s cannot be optional", word)
+			}
+			p.Literal = word
+		}
+		spec.params = append(spec.params, p)
+	}
+	return spec, nil
+}
+
+func MustParseArgSpec(usage string) *ArgSpec {
+	spec, err := ParseArgSpec(usage)
+	if err != nil {
+		panic("eppobot: bad argument spec " + strconv.Quote(usage) + ": " + err.Error())
+	}
+	return spec
+}
+
+// Parses "<name>", "<name...>" or "<name:type>"
+func parseArgName(word string) (argParam, error) {
+	p := argParam{Type: "string"}
+	if !strings.HasPrefix(word, "<") || !strings.HasSuffix(word, ">") {
+		return p, fmt.Errorf("expected <name>, got %s", word)
+	}
+	word = word[1 : len(word)-1]
+	if strings.HasSuffix(word, "...") {
+		p.Rest = true
+		word = word[:len(word)-3]
+	}
+	if i := strings.Index(word, ":"); i >= 0 {
+		p.Type = word[i+1:]
+		word = word[:i]
+		if p.Type != "int" && p.Type != "string" {
+			return p, fmt.Errorf("unknown type %s", p.Type)
+		}
+	}
+	if word == "" {
+		return p, fmt.Errorf("argument without a name")
+	}
+	p.Name = word
+	return p, nil
+}
+
+// Parse checks the text following a command against the spec.
+func (spec *ArgSpec) Parse(text string) (*Args, error) {
+	args := &Args{
+		values: make(map[string]string),
+		flags:  make(map[string]string),
+	}
+	fail := func(format string, a ...interface{}) (*Args, error) {
+		return nil, &UsageError{Usage: spec.Usage, Reason: fmt.Sprintf(format, a...)}
+	}
+
+	// Take out the flags first, they may appear anywhere
+	var tokens []argToken
+	for _, t := range tokenizeArgs(text) {
+		if t.Quoted || !strings.HasPrefix(t.Text, "--") {
+			tokens = append(tokens, t)
+			continue
+		}
+		name, value := t.Text[2:], ""
+		i := strings.Index(name, "=")
+		if i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		flag, ok := spec.flags[name]
+		if !ok {
+			// Not one of ours, so it must be part of the text
+			tokens = append(tokens, t)
+			continue
+		}
+		if flag.HasValue != (i >= 0) {
+			return fail("--%s verkeerd gebruikt", name)
+		}
+		if flag.Type == "int" {
+			if _, err := strconv.Atoi(value); err != nil {
+				return fail("--%s moet een getal zijn", name)
+			}
+		}
+		args.flags[name] = value
+	}
+
+	pos := 0
+	for i, p := range spec.params {
+		if p.Literal != "" {
+			if pos >= len(tokens) || !strings.EqualFold(tokens[pos].Text, p.Literal) {
+				return fail("%s ontbreekt", p.Literal)
+			}
+			pos++
+			continue
+		}
+		if pos >= len(tokens) {
+			if p.Optional {
+				continue
+			}
+			return fail("<%s> ontbreekt", p.Name)
+		}
+		var value string
+		if p.Rest {
+			// Take everything up to the next literal, or up to the end
+			end := len(tokens)
+			if i+1 < len(spec.params) && spec.params[i+1].Literal != "" {
+				end = pos
+				for end < len(tokens) && !strings.EqualFold(tokens[end].Text, spec.params[i+1].Literal) {
+					end++
+				}
+			}
+			if end == pos {
+				if p.Optional {
+					continue
+				}
+				return fail("<%s> ontbreekt", p.Name)
+			}
+			if end-pos == 1 {
+				value = tokens[pos].Text
+			} else {
+				value = joinTokens(text, tokens[pos:end])
+			}
+			pos = end
+		} else {
+			value = tokens[pos].Text
+			pos++
+		}
+		if p.Type == "int" {
+			if _, err := strconv.Atoi(value); err != nil {
+				if p.Optional && !p.Rest {
+					// Maybe it belongs to the next argument
+					pos--
+					continue
+				}
+				return fail("<%s> moet een getal zijn", p.Name)
+			}
+		}
+		args.values[p.Name] = value
+	}
+	if pos < len(tokens) {
+		return fail("te veel argumenten")
+	}
+	return args, nil
+}
+
+// Splits text into words, treating "quoted strings" as a single word.
+func tokenizeArgs(text string) []argToken {
+	var tokens []argToken
+	i := 0
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if unicode.IsSpace(r) {
+			i += size
+			continue
+		}
+		if r == '"' {
+			if end := strings.Index(text[i+1:], "\""); end >= 0 {
+				tokens = append(tokens, argToken{
+					Text:   text[i+1 : i+1+end],
+					Start:  i,
+					End:    i + end + 2,
+					Quoted: true,
+				})
+				i += end + 2
+				continue
+			}
+		}
+		start := i
+		for i < len(text) {
+			r, size := utf8.DecodeRuneInString(text[i:])
+			if unicode.IsSpace(r) {
+				break
+			}
+			i += size
+		}
+		tokens = append(tokens, argToken{Text: text[start:i], Start: start, End: i})
+	}
+	for i := range tokens {
+		tokens[i].Index = i
+	}
+	return tokens
+}
+
+// Returns the original text spanned by the tokens, leaving out any flags
+// that were between them.
+func joinTokens(text string, tokens []argToken) string {
+	joined := text[tokens[0].Start:tokens[0].End]
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i].Index == tokens[i-1].Index+1 {
+			joined += text[tokens[i-1].End:tokens[i].Start]
+		} else {
+			joined += " "
+		}
+		joined += text[tokens[i].Start:tokens[i].End]
+	}
+	return joined
+}
+
+// Get returns the value of an argument, or "" if it was not given.
+func (a *Args) Get(name string) string {
+	return a.values[name]
+}
+
+// Has tells whether an (optional) argument was given.
+func (a *Args) Has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+// Int returns the value of a numeric argument, or def if it was not given.
+func (a *Args) Int(name string, def int) int {
+	if n, err := strconv.Atoi(a.values[name]); err == nil {
+		return n
+	}
+	return def
+}
+
+// Flag returns the value of a flag and whether it was given at all.
+func (a *Args) Flag(name string) (string, bool) {
+	value, ok := a.flags[name]
+	return value, ok
+}
+
+// FlagInt returns the value of a numeric flag, or def if it was not given.
+func (a *Args) FlagInt(name string, def int) int {
+	if n, err := strconv.Atoi(a.flags[name]); err == nil {
+		return n
+	}
+	return def
+}
@@ -0,0 +1,62 @@
+package eppobot
+
+import (
+	"testing"
+)
+
+func TestArgSpec(test *testing.T) {
+	spec := MustParseArgSpec("<wie...> over <wat...>")
+	args, err := spec.Parse("Jan Salvador over  \"priem getallen\"")
+	if err != nil {
+		test.Fatal("Failed watzei spec with", err)
+	}
+	if args.Get("wie") != "Jan Salvador" || args.Get("wat") != "priem getallen" {
+		test.Errorf("Got wie=%q wat=%q", args.Get("wie"), args.Get("wat"))
+	}
+	if _, err := spec.Parse("Jan Salvador"); err == nil {
+		test.Error("Missing literal was accepted")
+	}
+
+	spec = MustParseArgSpec("[--aantal=<n:int>] [--stil] [<n:int>] [<filter...>]")
+	args, err = spec.Parse("brandweer --aantal=3 groningen")
+	if err != nil {
+		test.Fatal("Failed flag spec with", err)
+	}
+	if args.FlagInt("aantal", 1) != 3 || args.Has("n") || args.Get("filter") != "brandweer groningen" {
+		test.Errorf("Got aantal=%d n=%q filter=%q",
+			args.FlagInt("aantal", 1), args.Get("n"), args.Get("filter"))
+	}
+	if _, stil := args.Flag("stil"); stil {
+		test.Error("Flag --stil was not given")
+	}
+	args, err = spec.Parse("5 --stil")
+	if err != nil || args.Int("n", 1) != 5 {
+		test.Error("Failed optional number with", err)
+	}
+	if _, err := spec.Parse("--aantal=veel"); err == nil {
+		test.Error("Non-numeric flag was accepted")
+	}
+
+	if _, err := MustParseArgSpec("").Parse("iets"); err == nil {
+		test.Error("Superfluous argument was accepted")
+	}
+
+	// Letters like à and Å end in bytes that are spaces on their own
+	spec = MustParseArgSpec("<naam> <rest...>")
+	args, err = spec.Parse("Françoisà Åsa\u00a0en Zoë")
+	if err != nil || args.Get("naam") != "Françoisà" || args.Get("rest") != "Åsa\u00a0en Zoë" {
+		test.Errorf("Failed non-ASCII with naam=%q rest=%q, %v", args.Get("naam"), args.Get("rest"), err)
+	}
+}
+
+func TestUsage(test *testing.T) {
+	b := initDummyBot()
+	resps := b.chatResponse("!watzei Erik")
+	if resps.String() != "PRIVMSG #bottest :Daar snap ik helemaal niets van.\n" {
+		test.Error("Failed usage with", resps.String())
+	}
+	resps = <-b.Output
+	if resps.String() != "PRIVMSG someone :usage: !watzei <wie...> over <wat...>\n" {
+		test.Error("Failed usage with", resps.String())
+	}
+}
@@ -0,0 +1,266 @@
+package eppobot
+
+import (
+	"../catalog"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultBuildings = "buildings.json"
+	//Where a building with coordinates is on the map
+	defaultMapUrl = "https://www.openstreetmap.org/?mlat={lat}&mlon={lon}#map=18/{lat}/{lon}"
+	//Where one without them is looked up by its address
+	mapSearchUrl = "https://www.openstreetmap.org/search?query={address}"
+	//How many buildings !waaris shows, unless asked for more, and at most
+	buildingResults    = 3
+	buildingMaxResults = 10
+)
+
+//A building !waaris knows. Lat and Lon are zero if we don't know where it is
+//on the map.
+type Building struct {
+	Number  string
+	Address string
+	Name    string
+	Faculty string  `json:",omitempty"`
+	City    string  `json:",omitempty"`
+	Lat     float64 `json:",omitempty"`
+	Lon     float64 `json:",omitempty"`
+}
+
+//Read the buildings from the file in the config, buildings.json if it says
+//nothing. A file ending in .csv has a header row naming the columns: number,
+//address, name, faculty, city, lat and lon, in any order.
+func (b *QuoteBot) LoadBuildings() {
+	file := b.Buildings
+	if file == "" {
+		file = defaultBuildings
+	}
+	buildings, err := readBuildings(file)
+	if err != nil {
+		if b.Buildings != "" || !os.IsNotExist(err) {
+			log.Printf("Error loading buildings from %s: %s\n", file, err)
+		}
+		return
+	}
+	b.buildings = buildings
+}
+
+func readBuildings(file string) ([]Building, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(filepath.Ext(file)) != ".csv" {
+		var buildings []Building
+		err := json.Unmarshal(data, &buildings)
+		return buildings, err
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["number"]; !ok {
+		return nil, fmt.Errorf("no number column in the header")
+	}
+	var buildings []Building
+	for line, row := range rows[1:] {
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		building := Building{
+			Number:  get("number"),
+			Address: get("address"),
+			Name:    get("name"),
+			Faculty: get("faculty"),
+			City:    get("city"),
+		}
+		if get("lat") != "" || get("lon") != "" {
+			lat, errLat := strconv.ParseFloat(get("lat"), 64)
+			lon, errLon := strconv.ParseFloat(get("lon"), 64)
+			if errLat != nil || errLon != nil {
+				return nil, fmt.Errorf("line %d: coordinates %q, %q are no numbers", line+2, get("lat"), get("lon"))
+			}
+			building.Lat, building.Lon = lat, lon
+		}
+		buildings = append(buildings, building)
+	}
+	return buildings, nil
+}
+
+//Where the building is on the map: its coordinates if we know them, or else
+//a search for its address
+func (b *QuoteBot) mapLink(building Building) string {
+	if building.Lat == 0 && building.Lon == 0 {
+		address := strings.TrimSpace(building.Address + " " + building.City)
+		return strings.Replace(mapSearchUrl, "{address}", url.QueryEscape(address), -1)
+	}
+	link := b.MapUrl
+	if link == "" {
+		link = defaultMapUrl
+	}
+	link = strings.Replace(link, "{lat}", strconv.FormatFloat(building.Lat, 'f', -1, 64), -1)
+	return strings.Replace(link, "{lon}", strconv.FormatFloat(building.Lon, 'f', -1, 64), -1)
+}
+
+//How well the building fits the query; 0 if it doesn't. A number matches
+//the building number exactly, or its start. Otherwise every word must be in
+//the name, address or faculty: whole words count most, then their start,
+//then anywhere in a word, then with a typo or two. Short words only count
+//whole or at the start, or "7" would find half the buildings.
+func (building Building) score(query string) float64 {
+	query = strings.ToLower(strings.TrimSpace(query))
+	number := strings.ToLower(building.Number)
+	if query == number {
+		return 100
+	}
+	if _, err := strconv.Atoi(query); err == nil {
+		if strings.HasPrefix(number, query) {
+			return 50
+		}
+		return 0
+	}
+
+	text := strings.ToLower(strings.Join([]string{building.Name, building.Address, building.Faculty, building.Number}, " "))
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '(' || r == ')' || r == '/'
+	})
+	total := 0.0
+	for _, q := range strings.Fields(query) {
+		best := 0.0
+		for _, word := range words {
+			var s float64
+			switch {
+			case word == q:
+				s = 3
+			case strings.HasPrefix(word, q):
+				s = 2
+			case len(q) >= 3 && strings.Contains(word, q):
+				s = 1.5
+			case len(q) >= 4 && editDistance(word, q) <= 1:
+				s = 1
+			case len(q) >= 7 && editDistance(word, q) <= 2:
+				s = 0.5
+			}
+			if s > best {
+				best = s
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	//A name typed out as it is beats the same words scattered around
+	if strings.Contains(text, query) {
+		total += 2
+	}
+	return total
+}
+
+//How many letters have to change to make one word the other
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	previous := make([]int, len(t)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		current := make([]int, len(t)+1)
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(t)]
+}
+
+//The buildings that fit the query, best first, at most n
+func (b *QuoteBot) matchBuildings(query string, n int) []Building {
+	type scored struct {
+		Building
+		score float64
+	}
+	var found []scored
+	for _, building := range b.buildings {
+		if s := building.score(query); s > 0 {
+			found = append(found, scored{building, s})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].score > found[j].score
+	})
+	var best []Building
+	for i := 0; i < len(found) && i < n; i++ {
+		best = append(best, found[i].Building)
+	}
+	return best
+}
+
+//"!waaris bernoulliborg" tells where that is, and so does "!waaris 5161";
+//"!waaris --aantal=5 nijenborgh" shows the five that fit best
+func findBuilding(b *QuoteBot, in *IrcMessage, args *Args) {
+	n := args.FlagInt("aantal", buildingResults)
+	if n < 1 {
+		n = 1
+	}
+	if n > buildingMaxResults {
+		n = buildingMaxResults
+	}
+	found := b.matchBuildings(args.Get("gebouw"), n)
+	if len(found) == 0 {
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    b.text(in.Channel, "building.unknown", nil),
+		}
+		return
+	}
+	for _, building := range found {
+		key := "building"
+		if building.Faculty != "" && !CaseInsContains(building.Name, building.Faculty) {
+			key = "building.faculty"
+		}
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text: b.text(in.Channel, key, catalog.Params{
+				"number":  building.Number,
+				"address": building.Address,
+				"name":    building.Name,
+				"faculty": building.Faculty,
+				"map":     b.mapLink(building),
+			}),
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package eppobot
 
 import (
+	"../catalog"
+	"../feedbot"
+	"../p2000"
+	"../shortlink"
+	"../twitterbot"
 	"bufio"
 	"encoding/json"
 	"fmt"
@@ -20,6 +25,43 @@ type Config struct {
 	AutoOps   bool
 	Verbose   bool
 	Colors    bool
+	Prefixes  []string
+	Channels  map[string]ChannelConfig
+	Cooldowns map[string]Cooldown
+	Language  string
+	Messages  string
+	Persona   string
+	Scripts   string
+	//Nicknames that may post as the bot with !tweet
+	Tweeters []string
+	//Host masks of those who may use !urlexport, in private
+	Admins []string
+	//Whether to say what links in chat are, and of which domains not
+	Previews         bool
+	PreviewBlocklist []string
+	//How to shorten long links; see the shortlink package
+	Shortlinks shortlink.Config
+	//Where links seen in chat are kept, urls.json if left empty, and where
+	//!urlexport writes them, urls.csv if left empty
+	UrlLog    string
+	UrlExport string
+	//Where !sikknel reads P2000 messages; the next is tried when one fails
+	P2000 []p2000.Config
+	//Which P2000 alerts to announce without being asked
+	P2000Watch P2000Watch
+	//Where !waaris finds buildings, buildings.json if left empty, and the map
+	//they're shown on, with {lat} and {lon} for where they are
+	Buildings string
+	MapUrl    string
+}
+
+//Settings that may differ per channel. Anything left empty falls back to the
+//setting for the whole bot.
+type ChannelConfig struct {
+	Prefixes  []string
+	Cooldowns map[string]Cooldown
+	Language  string
+	Previews  *bool
 }
 
 type Quote struct {
@@ -32,13 +74,29 @@ type QuoteBot struct {
 	Reader     *bufio.Reader
 	Output     chan IrcOperation
 	InitLen    int
-	TwitterCtl chan string
+	TwitterCtl chan twitterbot.Request
+	FeedCtl    chan feedbot.Request
+	Texts      *catalog.Catalog
+	triggers   []ActionHandler
+	scripts    scriptEngine
+	limiter    rateLimiter
+	pending    pendingPosts
+	previews   previewer
+	shortener  shortlink.Shortener
+	urls       urlLog
+	p2k        p2000.Source
+	p2kSeen    p2000Watcher
+	buildings  []Building
+	//Our own short links, if we keep them; to be served on Shortlinks.Listen
+	Links *shortlink.Store
 }
 
 type IrcMessage struct {
 	Channel string
 	Text    string
 	Sender  string
+	//Who sent it in full, as in "erik!~erik@erik.example.com"
+	Hostmask string
 }
 
 type IrcCommand struct {
@@ -51,29 +109,77 @@ type IrcOperation interface {
 	Type() string
 }
 
+//Whatever the text, it's one line, or the rest would be read as commands
 func (m *IrcMessage) String() string {
-	return fmt.Sprintf("PRIVMSG %s :%s\n", m.Channel, m.Text)
+	return fmt.Sprintf("PRIVMSG %s :%s\n", oneLine(m.Channel), oneLine(m.Text))
 }
 func (m *IrcMessage) Type() string {
 	return "PRIVMSG"
 }
 
 func (o *IrcCommand) String() string {
-	return o.Command + " " + o.Arguments + "\n"
+	return oneLine(o.Command) + " " + oneLine(o.Arguments) + "\n"
+}
+
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "\x00", "")
+
+func oneLine(text string) string {
+	return lineBreaks.Replace(text)
 }
 func (o *IrcCommand) Type() string {
 	return o.Command
 }
 
 func CreateBot(conf Config, reader *bufio.Reader, output chan IrcOperation, qdb []Quote) *QuoteBot {
-	return &QuoteBot{
+	b := &QuoteBot{
 		Config:     conf,
 		Qdb:        qdb,
 		Reader:     reader,
 		Output:     output,
 		InitLen:    len(qdb),
 		TwitterCtl: nil,
+		FeedCtl:    nil,
+		Texts:      catalog.New(),
+	}
+	b.LoadMessages()
+	b.LoadPersona()
+	b.LoadScripts()
+	b.LoadShortener()
+	b.LoadUrlLog()
+	b.LoadP2000()
+	b.LoadBuildings()
+	return b
+}
+
+//Load the messages that replace the built-in ones, if there is a file for that
+func (b *QuoteBot) LoadMessages() {
+	if b.Messages == "" {
+		return
 	}
+	if err := b.Texts.Load(b.Messages); err != nil {
+		log.Printf("Error loading messages from %s: %s\n", b.Messages, err)
+	}
+}
+
+//Returns the message with the given key in the language of the channel
+func (b *QuoteBot) text(channel, key string, params catalog.Params) string {
+	return b.Texts.Text(b.Lang(channel), key, params)
+}
+
+func (b *QuoteBot) Lang(channel string) string {
+	if c, ok := b.channelConfig(channel); ok && c.Language != "" {
+		return c.Language
+	}
+	if b.Language != "" {
+		return b.Language
+	}
+	return catalog.DefaultLanguage
+}
+
+//How commands are given in the channel, the first of the prefixes if there
+//are more
+func (b *QuoteBot) Prefix(channel string) string {
+	return b.commandPrefixes(channel)[0]
 }
 
 func (b *QuoteBot) ChatContinuous() {
@@ -107,9 +213,10 @@ func (b *QuoteBot) ChatLine() {
 
 	if components[1] == "PRIVMSG" && len(components) >= 4 {
 		b.processChatMsg(IrcMessage{
-			Channel: components[2],
-			Text:    strings.TrimSpace(components[3][1:]),
-			Sender:  components[0][1:strings.Index(components[0], "!")],
+			Channel:  components[2],
+			Text:     strings.TrimSpace(components[3][1:]),
+			Sender:   components[0][1:strings.Index(components[0], "!")],
+			Hostmask: components[0][1:],
 		})
 	}
 
@@ -142,12 +249,94 @@ func (b *QuoteBot) processChatMsg(in IrcMessage) {
 		in.Channel = in.Sender
 	}
 
-	for _, ah := range messageToAction {
-		if matches := ah.Regexp.FindStringSubmatch(in.Text); matches != nil {
-			ah.Handler(b, &in, matches)
-			return
+	b.reloadChangedScripts()
+	text, isAddressed := b.addressedText(in.Text)
+	name, arguments, isCommand := b.splitCommand(in.Channel, text)
+	//First the built-in handlers, then the scripts, then the persona
+	for _, handlers := range [][]ActionHandler{messageToAction, b.scripts.handlers, b.triggers} {
+		for _, ah := range handlers {
+			var matches []string
+			switch {
+			case ah.Command != "":
+				if isCommand && name == ah.Command {
+					matches = []string{text, arguments}
+				}
+			case ah.Addressed:
+				if isAddressed {
+					matches = ah.Regexp.FindStringSubmatch(text)
+				}
+			default:
+				matches = ah.Regexp.FindStringSubmatch(in.Text)
+			}
+			if matches != nil && ah.Command != "" {
+				b.runLimited(ah, &in, matches)
+				return
+			}
+			if matches != nil {
+				ah.Handler(b, &in, matches)
+				return
+			}
+		}
+	}
+}
+
+//If the text starts by addressing the bot ("JanEppo: hoi", "JanEppo, hoi" or
+//"@JanEppo hoi"), return the rest of it.
+func (b *QuoteBot) addressedText(text string) (string, bool) {
+	rest := strings.TrimPrefix(text, "@")
+	at := len(rest) < len(text)
+	if len(rest) < len(b.Nickname) || !strings.EqualFold(rest[:len(b.Nickname)], b.Nickname) {
+		return text, false
+	}
+	rest = rest[len(b.Nickname):]
+	switch {
+	case strings.HasPrefix(rest, ":") || strings.HasPrefix(rest, ","):
+		rest = rest[1:]
+	case at && (rest == "" || rest[0] == ' '):
+	default:
+		//Someone else whose name starts with ours
+		return text, false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+//Split "!name arguments" into its parts, if it starts with one of the command
+//prefixes for the channel.
+func (b *QuoteBot) splitCommand(channel, text string) (name, arguments string, ok bool) {
+	for _, prefix := range b.commandPrefixes(channel) {
+		if !strings.HasPrefix(text, prefix) {
+			continue
+		}
+		parts := strings.SplitN(text[len(prefix):], " ", 2)
+		if parts[0] == "" {
+			continue
+		}
+		if len(parts) == 2 {
+			arguments = strings.TrimSpace(parts[1])
+		}
+		return parts[0], arguments, true
+	}
+	return "", "", false
+}
+
+func (b *QuoteBot) commandPrefixes(channel string) []string {
+	if c, ok := b.channelConfig(channel); ok && len(c.Prefixes) > 0 {
+		return c.Prefixes
+	}
+	if len(b.Prefixes) > 0 {
+		return b.Prefixes
+	}
+	return []string{"!"}
+}
+
+//IRC channel names are case insensitive, so the config might not match exactly
+func (b *QuoteBot) channelConfig(channel string) (ChannelConfig, bool) {
+	for name, c := range b.Channels {
+		if strings.EqualFold(name, channel) {
+			return c, true
 		}
 	}
+	return ChannelConfig{}, false
 }
 
 func IrcConnect(conf *Config) (bool, net.Conn, *bufio.Reader) {
@@ -1,11 +1,25 @@
 package eppobot
 
 import (
+	"../catalog"
+	"../feedbot"
+	"../p2000"
+	"../shortlink"
+	"../twitterbot"
 	"bufio"
+	"bytes"
 	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Return a new bot for testing. It has some quotes but no reader.
@@ -27,14 +41,18 @@ func initDummyBot() *QuoteBot {
 		Verbose:   false,
 		Colors:    true,
 	}
-	return &QuoteBot{
+	b := &QuoteBot{
 		Config:     conf,
 		Qdb:        qdb,
 		Reader:     nil,
 		Output:     make(chan IrcOperation),
 		InitLen:    len(qdb),
-		TwitterCtl: make(chan string),
+		TwitterCtl: make(chan twitterbot.Request),
+		FeedCtl:    make(chan feedbot.Request),
+		Texts:      catalog.New(),
 	}
+	b.LoadPersona()
+	return b
 }
 
 func (b *QuoteBot) response(command string) IrcOperation {
@@ -45,7 +63,7 @@ func (b *QuoteBot) response(command string) IrcOperation {
 
 // This is the common code for many tests
 func (b *QuoteBot) chatResponse(message string) IrcOperation {
-	return b.response(fmt.Sprintf("someone!somewhere PRIVMSG %s :%s", b.Channel, message))
+	return b.response(fmt.Sprintf(":someone!somewhere PRIVMSG %s :%s", b.Channel, message))
 }
 
 func TestCollega(test *testing.T) {
@@ -82,17 +100,776 @@ func TestPanic(test *testing.T) {
 	}()
 
 	// This should panic the bot (safety valve)
-	b.Reader = bufio.NewReader(strings.NewReader(fmt.Sprintf("someone!somewhere PRIVMSG %s :%s\n", b.Channel, b.Nickname+": verdwijn")))
+	b.Reader = bufio.NewReader(strings.NewReader(fmt.Sprintf(":someone!somewhere PRIVMSG %s :%s\n", b.Channel, b.Nickname+": verdwijn")))
 	go func() {
 		test.Log(<-b.Output)
 	}()
 	b.ChatLine()
 }
 
+func TestOneLine(test *testing.T) {
+	m := &IrcMessage{Channel: "#bottest", Text: "Hallo\r\nQUIT :weg\nJOIN #elders\x00"}
+	if m.String() != "PRIVMSG #bottest :Hallo QUIT :weg JOIN #elders\n" {
+		test.Errorf("Sent %q", m.String())
+	}
+}
+
 func TestSikknel(test *testing.T) {
+	page, err := ioutil.ReadFile("../p2000/testdata/current.html")
+	if err != nil {
+		test.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(page)
+	}))
+	defer server.Close()
 	b := initDummyBot()
+	b.Cooldowns = map[string]Cooldown{"sikknel": Cooldown{}}
+	b.p2k = &p2000.Page{Url: server.URL}
+
 	resps := b.chatResponse("!sikknel")
-	if !strings.Contains(resps.String(), fmt.Sprintf("PRIVMSG %s :P", b.Channel)) {
-		test.Error("Sikknel returns >", resps.String(), "< but expected >", fmt.Sprintf("PRIVMSG %s :P", b.Channel), "<")
+	if resps.String() != "PRIVMSG #bottest :14:03 P 1 BDH-01 Middelbrand (Wonen) Grote Markt Groningen 012331\n" {
+		test.Error("Failed sikknel with", resps.String())
+	}
+	resps = b.chatResponse("!sikknel 2 ambulance p3")
+	if resps.String() != "PRIVMSG #bottest :13:58 A2 Ambu 01144 Zernikelaan 9747AA Groningen ZERNIK bon 67534\n" {
+		test.Error("Failed filtered sikknel with", resps.String())
+	}
+	resps = <-b.Output
+	if resps.String() != "PRIVMSG #bottest :13:22 B2 Ambu 01122 Rit 56012 Haren\n" {
+		test.Error("Failed second message with", resps.String())
+	}
+	resps = b.chatResponse("!sikknel politie groningen")
+	if resps.String() != "PRIVMSG #bottest :Niets gevonden.\n" {
+		test.Error("Failed nothing found with", resps.String())
+	}
+
+	b.p2k = &p2000.Page{Url: server.URL + "/weg"}
+	server.Close()
+	resps = b.chatResponse("!sikknel")
+	if !strings.Contains(resps.String(), "De scanner zwijgt") {
+		test.Error("Failed unreachable site with", resps.String())
+	}
+}
+
+func TestP2000Watch(test *testing.T) {
+	page, err := ioutil.ReadFile("../p2000/testdata/current.html")
+	if err != nil {
+		test.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(page)
+	}))
+	defer server.Close()
+	dir, _ := ioutil.TempDir("", "p2000")
+	defer os.RemoveAll(dir)
+	now := time.Date(2026, 10, 19, 14, 10, 0, 0, p2000.DutchTime)
+
+	newBot := func(quiet string) *QuoteBot {
+		b := initDummyBot()
+		b.p2k = &p2000.Page{Url: server.URL}
+		b.P2000Watch = P2000Watch{
+			Channel:   "#alarm",
+			Postcodes: []string{"9747"},
+			Streets:   []string{"grote markt", "Vismarkt"},
+			Capcodes:  []string{"0123146"},
+			Quiet:     quiet,
+			File:      filepath.Join(dir, "seen.json"),
+		}
+		b.loadP2000Watch()
+		return b
+	}
+	// Run a check, and return what it announced
+	check := func(b *QuoteBot, now time.Time) []string {
+		done := make(chan bool)
+		go func() {
+			b.checkP2000(now)
+			close(done)
+		}()
+		var said []string
+		for {
+			select {
+			case out := <-b.Output:
+				said = append(said, out.String())
+			case <-done:
+				return said
+			}
+		}
+	}
+
+	// In quiet hours, they're only noted; those go by the Dutch clock
+	if said := check(newBot("14:00-15:00"), now.UTC()); len(said) != 0 {
+		test.Error("Announced in quiet hours", said)
+	}
+	os.Remove(filepath.Join(dir, "seen.json"))
+
+	b := newBot("23:00-07:00")
+	said := check(b, now)
+	if len(said) != 2 || said[0] != "PRIVMSG #alarm :Sikknel in de buurt! 13:58 A2 Ambu 01144 Zernikelaan 9747AA Groningen ZERNIK bon 67534\n" ||
+		said[1] != "PRIVMSG #alarm :Sikknel in de buurt! 14:03 P 1 BDH-01 Middelbrand (Wonen) Grote Markt Groningen 012331\n" {
+		test.Error("Failed alerts with", said)
+	}
+	if said := check(b, now.Add(2*time.Minute)); len(said) != 0 {
+		test.Error("Announced again", said)
+	}
+	// Also after a restart
+	if said := check(newBot(""), now.Add(4*time.Minute)); len(said) != 0 {
+		test.Error("Announced again after a restart", said)
+	}
+
+	// Alerts that are old by the time we see them are not news
+	os.Remove(filepath.Join(dir, "seen.json"))
+	if said := check(newBot(""), now.Add(2*time.Hour)); len(said) != 0 {
+		test.Error("Announced old alerts", said)
+	}
+}
+
+func TestBuildings(test *testing.T) {
+	b := initDummyBot()
+	b.Buildings = "../buildings.json"
+	b.LoadBuildings()
+	lines := func(message string, count int) []string {
+		b.Reader = bufio.NewReader(strings.NewReader(":someone!somewhere PRIVMSG #bottest :" + message + "\n"))
+		go b.ChatLine()
+		var texts []string
+		for i := 0; i < count; i++ {
+			texts = append(texts, (<-b.Output).(*IrcMessage).Text)
+		}
+		return texts
+	}
+	bernoulliborg := "5161. Nijenborgh 9, Faculty board and general offices Bernoulliborg (Science and Engineering) " +
+		"https://www.openstreetmap.org/search?query=Nijenborgh+9+Groningen"
+	if texts := lines("!waaris 5161", 1); texts[0] != bernoulliborg {
+		test.Error("Failed building number with", texts)
+	}
+	if texts := lines("!waaris bernouliborg", 1); texts[0] != bernoulliborg {
+		test.Error("Failed typo with", texts)
+	}
+	if texts := lines("!waaris harmonie", 2); !strings.HasPrefix(texts[0], "1311. ") || !strings.HasPrefix(texts[1], "1312. ") {
+		test.Error("Failed several buildings with", texts)
+	}
+	if texts := lines("!waaris --aantal=2 51", 2); !strings.HasPrefix(texts[0], "5111. ") || !strings.HasPrefix(texts[1], "5112. ") {
+		test.Error("Failed --aantal with", texts)
+	}
+	if texts := lines("!waaris nijenborgh 7", 1); !strings.HasPrefix(texts[0], "5172. Nijenborgh 7, Biology") {
+		test.Error("Failed ranking with", texts)
+	}
+	if texts := lines("!waaris 9999", 1); texts[0] != b.text("#bottest", "building.unknown", nil) {
+		test.Error("Failed unknown building with", texts)
+	}
+
+	// Another university, in CSV and with coordinates
+	dir, _ := ioutil.TempDir("", "buildings")
+	defer os.RemoveAll(dir)
+	b.Buildings = filepath.Join(dir, "gebouwen.csv")
+	ioutil.WriteFile(b.Buildings, []byte("Name,Number,Lat,Lon,Address\n"+
+		"Zilverling,ZI,52.2393,6.8566,Hallenweg 19\n"+
+		"\"Waaier, de\",WA,52.2386,6.8558,Hallenweg 23\n"), 0644)
+	b.LoadBuildings()
+	if texts := lines("!waaris waaier", 1); texts[0] != "WA. Hallenweg 23, Waaier, de "+
+		"https://www.openstreetmap.org/?mlat=52.2386&mlon=6.8558#map=18/52.2386/6.8558" {
+		test.Error("Failed CSV building with", texts)
+	}
+	if texts := lines("!waaris zi", 1); !strings.HasPrefix(texts[0], "ZI. Hallenweg 19, Zilverling") {
+		test.Error("Failed building code with", texts)
+	}
+}
+
+func TestAddressing(test *testing.T) {
+	b := initDummyBot()
+	b.Nickname = "Test-Bot|afk"
+	b.Channels = map[string]ChannelConfig{"#BotTest": ChannelConfig{Prefixes: []string{"."}}}
+	for _, line := range []string{"Test-Bot|afk: verdwijn", "test-bot|AFK, verdwijn", "@Test-Bot|afk verdwijn"} {
+		text, ok := b.addressedText(line)
+		if !ok || text != "verdwijn" {
+			test.Errorf("Failed addressing with %q, got %q", line, text)
+		}
+	}
+	if _, ok := b.addressedText("Test-Bot|afkeurig: hoi"); ok {
+		test.Error("Addressing matched a longer nickname")
+	}
+
+	if _, _, ok := b.splitCommand(b.Channel, "!collega Erik"); ok {
+		test.Error("Channel prefix did not override the default")
+	}
+	name, arguments, ok := b.splitCommand(b.Channel, ".collega  Erik")
+	if !ok || name != "collega" || arguments != "Erik" {
+		test.Errorf("Failed command with %q %q", name, arguments)
+	}
+	name, _, ok = b.splitCommand("#elders", "!sl")
+	if !ok || name != "sl" {
+		test.Error("Default prefix is not used in other channels")
+	}
+}
+
+func TestCooldown(test *testing.T) {
+	b := initDummyBot()
+	b.Cooldowns = map[string]Cooldown{"collega": Cooldown{User: 10, Channel: 5}}
+	erik := &IrcMessage{Channel: b.Channel, Sender: "Erik"}
+	harm := &IrcMessage{Channel: b.Channel, Sender: "Harm"}
+	start := time.Now()
+
+	if ok, _ := b.allowCommand(erik, "collega", start); !ok {
+		test.Error("First use was refused")
+	}
+	if ok, wait := b.allowCommand(harm, "collega", start.Add(2*time.Second)); ok || wait != 3 {
+		test.Error("Channel cooldown was not applied, wait", wait)
+	}
+	if ok, _ := b.allowCommand(harm, "collega", start.Add(6*time.Second)); !ok {
+		test.Error("Channel cooldown did not expire")
+	}
+	// Erik may go again at 10s, but Harm has held up the channel until 11s
+	if ok, wait := b.allowCommand(erik, "collega", start.Add(7*time.Second)); ok || wait != 4 {
+		test.Error("User cooldown was not applied, wait", wait)
+	}
+	if ok, _ := b.allowCommand(erik, "wiezei", start.Add(7*time.Second)); !ok {
+		test.Error("Command without cooldown was refused")
+	}
+
+	// Uses that have run out are forgotten
+	b.useCommand(harm, "collega", start.Add(20*time.Second))
+	if len(b.limiter.until) != 2 {
+		test.Error("Failed to forget old uses,", b.limiter.until)
+	}
+
+	// Getting the arguments wrong doesn't use up the command
+	b.Cooldowns["wiezei"] = Cooldown{User: 60}
+	if r := b.chatResponse("!wiezei"); r.String() != fmt.Sprintf("PRIVMSG %s :%s\n", b.Channel, b.text(b.Channel, "confused", nil)) {
+		test.Error("Failed wrong arguments with", r.String())
+	}
+	<-b.Output
+	cooldown := b.text(b.Channel, "cooldown", catalog.Params{"sender": "someone", "seconds": 60})
+	if r := b.chatResponse("!wiezei koffie"); strings.Contains(r.String(), cooldown) {
+		test.Error("Wrong arguments used up the cooldown")
+	}
+	if r := b.chatResponse("!wiezei thee"); !strings.Contains(r.String(), cooldown) {
+		test.Error("Cooldown was not applied after a good use, got", r.String())
+	}
+}
+
+func TestPersona(test *testing.T) {
+	f, err := ioutil.TempFile("", "persona")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"Triggers": [
+		{"Command": "jarig", "Replies": ["Hoera voor {args}, zegt {sender}!"]},
+		{"Pattern": "^gang", "Replies": [""]}
+	]}`)
+	f.Close()
+
+	b := initDummyBot()
+	b.Persona = f.Name()
+	b.LoadPersona()
+	resps := b.chatResponse("!jarig Harm")
+	if resps.String() != "PRIVMSG #bottest :Hoera voor Harm, zegt someone!\n" {
+		test.Error("Failed persona command with", resps.String())
+	}
+
+	// The default triggers are still there, unless silenced. A silenced
+	// trigger says nothing, so ChatLine would return without output.
+	b.Reader = bufio.NewReader(strings.NewReader(":someone!somewhere PRIVMSG #bottest :gang\n"))
+	b.ChatLine()
+	resps = b.chatResponse("!college")
+	if resps.String() != "PRIVMSG #bottest :Ik geef helaas geen colleges meer, ik ben met pensioen!\n" {
+		test.Error("Failed default trigger with", resps.String())
+	}
+}
+
+func TestScripts(test *testing.T) {
+	dir, err := ioutil.TempDir("", "scripts")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "teller.star"), []byte(`
+def tel(msg):
+	n = get("n", 0) + int(msg.args.get("stap", "1"))
+	put("n", n)
+	reply("%s telt tot %d, er zijn %d quotes van Erik" % (msg.sender, n, len(quotes(name="erik"))))
+
+def echo(msg):
+	tell(msg.groups[1])
+
+def lus(msg):
+	for i in range(100000000):
+		pass
+	reply("klaar")
+
+def groot(msg):
+	reply("%d" % randint(0, 9223372036854775807))
+
+def spam(msg):
+	for i in range(100):
+		reply("spam %d" % i)
+
+command("tel", tel, "[<stap:int>]")
+command("lus", lus)
+command("spam", spam)
+command("groot", groot)
+trigger("^echo (.*)$", echo)
+`), 0644)
+
+	b := initDummyBot()
+	b.Scripts = dir
+	b.LoadScripts()
+	resps := b.chatResponse("!tel 2")
+	if resps.String() != "PRIVMSG #bottest :someone telt tot 2, er zijn 1 quotes van Erik\n" {
+		test.Error("Failed script command with", resps.String())
+	}
+	resps = b.chatResponse("echo hallo")
+	if resps.String() != "PRIVMSG someone :hallo\n" {
+		test.Error("Failed script trigger with", resps.String())
+	}
+
+	// Scripts that go on too long are stopped, and say nothing more
+	b.Reader = bufio.NewReader(strings.NewReader(":someone!somewhere PRIVMSG #bottest :!lus\n"))
+	b.ChatLine()
+	// A range too wide for Go is an error in the script, not a crash
+	b.Reader = bufio.NewReader(strings.NewReader(":someone!somewhere PRIVMSG #bottest :!groot\n"))
+	b.ChatLine()
+	b.Reader = bufio.NewReader(strings.NewReader(":someone!somewhere PRIVMSG #bottest :!spam\n"))
+	go b.ChatLine()
+	for i := 0; i < scriptMaxLines; i++ {
+		<-b.Output
+	}
+	resps = b.chatResponse("echo nog")
+	if resps.String() != "PRIVMSG someone :nog\n" {
+		test.Error("Script said too much, got", resps.String())
+	}
+
+	// The state survives a restart
+	b = initDummyBot()
+	b.Scripts = dir
+	b.LoadScripts()
+	resps = b.chatResponse("!tel")
+	if resps.String() != "PRIVMSG #bottest :someone telt tot 3, er zijn 1 quotes van Erik\n" {
+		test.Error("Failed script state with", resps.String())
+	}
+}
+
+func TestTwitterReply(test *testing.T) {
+	b := initDummyBot()
+	go func() {
+		r := <-b.TwitterCtl
+		if r.Command == twitterbot.CTL_OUTPUT_LINK {
+			r.Reply <- "link naar " + r.Arg
+		}
+		close(r.Reply)
+	}()
+	// Asked in private, so the answer should come in private
+	resps := b.response(":someone!somewhere PRIVMSG TestBot :!link harm")
+	if resps.String() != "PRIVMSG someone :link naar harm\n" {
+		test.Error("Failed twitter reply with", resps.String())
+	}
+
+	// Replays are always private
+	go func() {
+		r := <-b.TwitterCtl
+		if r.Command == twitterbot.CTL_OUTPUT_TWEETS {
+			r.Reply <- fmt.Sprintf("%d van %s", r.Count, r.Arg)
+		}
+		close(r.Reply)
+	}()
+	resps = b.chatResponse("!tweets harm 3")
+	if resps.String() != "PRIVMSG someone :3 van harm\n" {
+		test.Error("Failed replay with", resps.String())
+	}
+}
+
+func TestBotsOff(test *testing.T) {
+	b := initDummyBot()
+	b.TwitterCtl, b.FeedCtl = nil, nil
+	for message, key := range map[string]string{"!link": "twitter.off", "!fixtwitter": "twitter.off", "!feeds": "feeds.off"} {
+		if resps := b.chatResponse(message); resps.String() != "PRIVMSG #bottest :"+b.text("#bottest", key, nil)+"\n" {
+			test.Errorf("Failed %s without a bot with %s", message, resps.String())
+		}
+	}
+}
+
+func TestAddFeed(test *testing.T) {
+	b := initDummyBot()
+	requests := make(chan feedbot.Request, 1)
+	go func() {
+		for r := range b.FeedCtl {
+			r.Reply <- "ok " + r.Feed.Name
+			close(r.Reply)
+			requests <- r
+		}
+	}()
+
+	// A long link in a command is not for shortening
+	b.UrlLength = 10
+	resps := b.chatResponse("!addfeed Blog https://example.com/blog/feed.xml --minuten=30")
+	if resps.String() != "PRIVMSG #bottest :ok Blog\n" {
+		test.Error("Failed addfeed with", resps.String())
+	}
+	r := <-requests
+	if r.Feed.Url != "https://example.com/blog/feed.xml" || r.Feed.Interval != 30 ||
+		len(r.Feed.Channels) != 1 || r.Feed.Channels[0] != "#bottest" {
+		test.Errorf("Failed addfeed with request %+v", r)
+	}
+
+	// Asked in private, it goes to the main channel
+	resps = b.response(":someone!somewhere PRIVMSG TestBot :!addfeed Nieuws http://example.com/rss")
+	if resps.String() != "PRIVMSG someone :ok Nieuws\n" {
+		test.Error("Failed private addfeed with", resps.String())
+	}
+	if r = <-requests; len(r.Feed.Channels) != 0 || r.Feed.Interval != 0 {
+		test.Errorf("Failed private addfeed with request %+v", r)
+	}
+}
+
+func TestTwitterFilter(test *testing.T) {
+	b := initDummyBot()
+	requests := make(chan twitterbot.Request, 1)
+	go func() {
+		for r := range b.TwitterCtl {
+			close(r.Reply)
+			requests <- r
+		}
+	}()
+
+	// The twitterbot doesn't answer, so neither do we
+	say := func(message string) twitterbot.Request {
+		b.Reader = bufio.NewReader(strings.NewReader(":someone!somewhere PRIVMSG #bottest :" + message + "\n"))
+		b.ChatLine()
+		return <-requests
+	}
+	if r := say("!filter erik"); r.Command != twitterbot.CTL_FILTER || r.Arg != "erik" || r.Filter != nil {
+		test.Errorf("Failed showing filter with %+v", r)
+	}
+	r := say("!filter erik --retweets=uit --anderen=ja --niet=koffie")
+	if r.Filter == nil || r.Filter.Retweets == nil || *r.Filter.Retweets || r.Filter.Others == nil || !*r.Filter.Others ||
+		r.Filter.Replies != nil || r.Filter.Exclude != "koffie" || r.Filter.Clear {
+		test.Errorf("Failed changing filter with %+v", r.Filter)
+	}
+	r = say("!filter erik --wel=thee --citaten=nee --wis")
+	if r.Filter == nil || !r.Filter.Clear || r.Filter.Include != "thee" || r.Filter.Quotes == nil || *r.Filter.Quotes {
+		test.Errorf("Failed clearing filter with %+v", r.Filter)
+	}
+
+	resps := b.chatResponse("!filter erik --retweets=misschien")
+	if resps.String() != "PRIVMSG #bottest :"+b.text("#bottest", "confused", nil)+"\n" {
+		test.Error("Failed bad filter value with", resps.String())
+	}
+}
+
+func TestPostTweet(test *testing.T) {
+	b := initDummyBot()
+	b.Tweeters = []string{"someone", "Someone!*@somewhere"}
+	requests := make(chan twitterbot.Request, 1)
+	go func() {
+		for r := range b.TwitterCtl {
+			close(r.Reply)
+			requests <- r
+		}
+	}()
+	say := func(message string) {
+		b.Reader = bufio.NewReader(strings.NewReader(":someone!home@somewhere PRIVMSG #bottest :" + message + "\n"))
+		b.ChatLine()
+	}
+	ask := func(message string) IrcOperation {
+		return b.response(":someone!home@somewhere PRIVMSG #bottest :" + message)
+	}
+
+	resps := b.response(":other!elsewhere PRIVMSG #bottest :!tweet Hallo")
+	if resps.String() != "PRIVMSG #bottest :"+b.text("#bottest", "tweet.denied", nil)+"\n" {
+		test.Error("Failed denying with", resps.String())
+	}
+	// Taking the nickname is not enough
+	resps = b.response(":someone!somewhere@elsewhere PRIVMSG #bottest :!tweet Hallo")
+	if resps.String() != "PRIVMSG #bottest :"+b.text("#bottest", "tweet.denied", nil)+"\n" {
+		test.Error("Failed denying a borrowed nickname with", resps.String())
+	}
+
+	// First we see what it would be, then it's sent
+	say("!tweet Hallo wereld")
+	if r := <-requests; r.Command != twitterbot.CTL_PREVIEW_POST || r.Arg != "Hallo wereld" {
+		test.Errorf("Failed preview with %+v", r)
+	}
+	// A tweet is not sent with !toot, that's a toot of its own
+	say("!toot ja")
+	if r := <-requests; r.Command != twitterbot.CTL_PREVIEW_POST || r.Arg != "ja" || r.Name != "toot" {
+		test.Errorf("Failed toot with %+v", r)
+	}
+	say("!tweet ja")
+	if r := <-requests; r.Command != twitterbot.CTL_POST || r.Arg != "Hallo wereld" || r.Name != "tweet" {
+		test.Errorf("Failed post with %+v", r)
+	}
+	// The confirmation tells how to say yes where it was asked
+	b.Prefixes = []string{"."}
+	say(".toot Hallo")
+	if r := <-requests; r.Command != twitterbot.CTL_PREVIEW_POST || r.Prefix != "." || r.Name != "toot" {
+		test.Errorf("Failed toot preview with %+v", r)
+	}
+	ask(".toot nee")
+	b.Prefixes = nil
+	// Nothing waits any more, so this is something new to send
+	say("!tweet ja")
+	if r := <-requests; r.Command != twitterbot.CTL_PREVIEW_POST || r.Arg != "ja" {
+		test.Errorf("Failed second preview with %+v", r)
+	}
+	resps = ask("!tweet nee")
+	if resps.String() != "PRIVMSG #bottest :"+b.text("#bottest", "tweet.cancelled", nil)+"\n" {
+		test.Error("Failed cancelling with", resps.String())
+	}
+
+	say("!deltweet")
+	if r := <-requests; r.Command != twitterbot.CTL_DELETE_POST {
+		test.Errorf("Failed delete with %+v", r)
+	}
+}
+
+func TestPreview(test *testing.T) {
+	var picture bytes.Buffer
+	png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 3, 2)))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pagina", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Oude titel</title>
+			<meta property="og:title" content="Nieuwe  titel">
+			<meta property="og:description" content="Over
+			van alles"></head><body><title>Niet deze</title></body></html>`)
+	})
+	mux.HandleFunc("/kaal", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Alleen een titel</title></head></html>`)
+	})
+	mux.HandleFunc("/video", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Video</title>
+			<link rel="alternate" type="application/json+oembed" href="/oembed?url=video"></head></html>`)
+	})
+	mux.HandleFunc("/elders", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><title>Video elders</title>
+			<link rel="alternate" type="application/json+oembed" href="http://localhost:%s/oembed"></head></html>`, strings.Split(r.Host, ":")[1])
+	})
+	mux.HandleFunc("/kleur", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><head><title>\x01ACTION zwaait\x01 en \x02vet\x02\tklaar</title></head></html>")
+	})
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"title": "Katten", "author_name": "Erik", "provider_name": "Buis"}`)
+	})
+	mux.HandleFunc("/plaatje", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(picture.Bytes())
+	})
+	mux.HandleFunc("/verslag.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		fmt.Fprint(w, "%PDF-1.4\n1 0 obj << /Title (Jaar\\(verslag\\) \\3442) /Author (Harm) >> endobj\n")
+	})
+	mux.HandleFunc("/utf16.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		fmt.Fprint(w, "%PDF-1.7\n<< /Title <FEFF 004B 0061 0074> >>\n")
+	})
+	mux.HandleFunc("/zip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		fmt.Fprint(w, "PK")
+	})
+	mux.Handle("/omweg", http.RedirectHandler("/pagina", http.StatusFound))
+	mux.Handle("/rondje", http.RedirectHandler("/rondje", http.StatusFound))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	b := initDummyBot()
+	b.Previews = true
+	b.previews.allowLocal = true
+	tests := []struct{ path, text string }{
+		{"/pagina", "↳ Nieuwe titel: Over van alles"},
+		{"/kaal", "↳ Alleen een titel"},
+		{"/omweg", "↳ Nieuwe titel: Over van alles"},
+		{"/video", "↳ Katten, door Erik (Buis)"},
+		{"/plaatje", "↳ Plaatje: PNG, 3×2 pixels"},
+		{"/verslag.pdf", "↳ PDF: Jaar(verslag) ä2"},
+		{"/utf16.pdf", "↳ PDF: Kat"},
+		{"/kleur", "↳ ACTION zwaait en vet klaar"},
+	}
+	for _, t := range tests {
+		preview := b.preview(server.URL + t.path)
+		if preview == nil {
+			test.Error("No preview of", t.path)
+			continue
+		}
+		if text := b.previewText("#bottest", preview); text != t.text {
+			test.Errorf("Failed preview of %s with %q", t.path, text)
+		}
+	}
+	for _, path := range []string{"/zip", "/rondje", "/weg"} {
+		if preview := b.preview(server.URL + path); preview != nil {
+			test.Errorf("Previewed %s with %+v", path, preview)
+		}
+	}
+
+	// A blocked domain is not asked for the oEmbed either, the page has to do
+	b.PreviewBlocklist = []string{"localhost"}
+	if preview := b.preview(server.URL + "/elders"); preview == nil || preview.Kind != "page" || preview.Title != "Video elders" {
+		test.Errorf("Failed oEmbed on a blocked domain with %+v", preview)
+	}
+	b.PreviewBlocklist = nil
+
+	// In chat, with the cache
+	server.Close()
+	resps := b.chatResponse("Kijk: (" + server.URL + "/pagina).")
+	if resps.String() != "PRIVMSG #bottest :↳ Nieuwe titel: Over van alles\n" {
+		test.Error("Failed preview in chat with", resps.String())
+	}
+
+	// Not where it's off, not for blocked domains, and not from close by
+	off := false
+	b.Channels = map[string]ChannelConfig{"#stil": {Previews: &off}}
+	if b.previewsOn("#stil") || !b.previewsOn("#bottest") {
+		test.Error("Failed previews per channel")
+	}
+	b.PreviewBlocklist = []string{"example.com"}
+	if !b.previewBlocked("www.Example.com") || !b.previewBlocked("example.com") || b.previewBlocked("notexample.com") {
+		test.Error("Failed blocklist")
+	}
+	if preview := b.preview("https://sub.example.com/pagina"); preview != nil {
+		test.Error("Previewed a blocked domain with", preview)
+	}
+	b = initDummyBot()
+	if _, err := b.fetchPreview("http://127.0.0.1:1/"); err == nil {
+		test.Error("Fetched from the local network")
+	}
+}
+
+func TestFindLinks(test *testing.T) {
+	links := findLinks(`Zie "https://a.nl/x", (http://b.nl) en ftp://c.nl of https:// of http://d.nl/e?f=g!`)
+	if strings.Join(links, " ") != "https://a.nl/x http://b.nl http://d.nl/e?f=g" {
+		test.Error("Found links", links)
+	}
+}
+
+func TestShortLinks(test *testing.T) {
+	b := initDummyBot()
+	if resps := b.chatResponse("!short https://example.com/"); resps.String() != "PRIVMSG #bottest :"+b.text("#bottest", "short.off", nil)+"\n" {
+		test.Error("Failed short without shortener with", resps.String())
+	}
+
+	dir, _ := ioutil.TempDir("", "shortlinks")
+	defer os.RemoveAll(dir)
+	b.Shortlinks = shortlink.Config{BaseUrl: "https://eppo.nl/s", File: filepath.Join(dir, "links.json")}
+	b.LoadShortener()
+	b.UrlLength = 30
+	b.PreviewBlocklist = []string{"example.com"}
+
+	// Long links in chat are shortened, short ones aren't
+	resps := b.chatResponse("Kijk: https://example.com/een/heel/lang/pad?met=van&alles")
+	short := strings.TrimSuffix(strings.TrimPrefix(resps.String(), "PRIVMSG #bottest :"), "\n")
+	if !strings.HasPrefix(short, "https://eppo.nl/s/") {
+		test.Error("Failed shortening with", resps.String())
+	}
+	resps = b.chatResponse("!short https://example.com/")
+	if !strings.HasPrefix(resps.String(), "PRIVMSG #bottest :https://eppo.nl/s/") || strings.Contains(resps.String(), short) {
+		test.Error("Failed short with", resps.String())
+	}
+	resps = b.chatResponse("!short example")
+	if resps.String() != "PRIVMSG #bottest :"+b.text("#bottest", "short.invalid", nil)+"\n" {
+		test.Error("Failed short of no link with", resps.String())
+	}
+	resps = b.response(":someone!somewhere PRIVMSG TestBot :!short https://example.com/prive")
+	if resps.String() != "PRIVMSG someone :"+b.text("someone", "short.private", nil)+"\n" {
+		test.Error("Failed short in private with", resps.String())
+	}
+	b.Links.MaxPerUser = 2
+	resps = b.chatResponse("!short https://example.com/nog/een")
+	if resps.String() != "PRIVMSG #bottest :"+b.text("#bottest", "short.toomany", nil)+"\n" {
+		test.Error("Failed short over the limit with", resps.String())
+	}
+
+	resps = b.chatResponse("!shortstats " + short)
+	if !strings.Contains(resps.String(), "https://example.com/een/heel/lang/pad?met=van&alles") ||
+		!strings.Contains(resps.String(), "nog nooit gevolgd") || !strings.Contains(resps.String(), "someone") {
+		test.Error("Failed shortstats with", resps.String())
+	}
+	resps = b.chatResponse("!shortstats abcdef")
+	if resps.String() != "PRIVMSG #bottest :"+b.text("#bottest", "short.unknown", nil)+"\n" {
+		test.Error("Failed shortstats of an unknown link with", resps.String())
+	}
+}
+
+func TestUrlLog(test *testing.T) {
+	dir, _ := ioutil.TempDir("", "urls")
+	defer os.RemoveAll(dir)
+	b := initDummyBot()
+	b.UrlLog = filepath.Join(dir, "urls.json")
+	b.UrlExport = filepath.Join(dir, "urls.csv")
+	b.LoadUrlLog()
+	b.PreviewBlocklist = []string{"example.com", "example.org"}
+	say := func(sender, channel, message string) {
+		b.Reader = bufio.NewReader(strings.NewReader(":" + sender + "!somewhere PRIVMSG " + channel + " :" + message + "\n"))
+		b.ChatLine()
+	}
+	say("erik", "#bottest", "Koffie: https://example.com/koffie?utm_source=irc")
+	b.logTitle("#bottest", "https://example.com/koffie?utm_source=irc", "Alles over koffie")
+	say("harm", "#bottest", "https://example.org/thee en https://example.org/water")
+	say("harm", "#elders", "https://example.com/koffie")
+	// Posting it again yourself is fine
+	say("erik", "#bottest", "https://example.com/koffie#boven")
+
+	resps := b.response(":mark!somewhere PRIVMSG #bottest :Kijk https://Example.com/koffie/")
+	if !strings.HasPrefix(resps.String(), "PRIVMSG #bottest :Oud nieuws! Al gepost door erik op "+time.Now().Format("2006-01-02")) {
+		test.Error("Failed old link with", resps.String())
+	}
+
+	lines := func(message string, count int) []string {
+		b.Reader = bufio.NewReader(strings.NewReader(":someone!somewhere PRIVMSG #bottest :" + message + "\n"))
+		go b.ChatLine()
+		var texts []string
+		for i := 0; i < count; i++ {
+			texts = append(texts, (<-b.Output).(*IrcMessage).Text)
+		}
+		return texts
+	}
+	if texts := lines("!urls", 5); !strings.HasSuffix(texts[0], "mark: https://Example.com/koffie/") ||
+		!strings.HasSuffix(texts[4], "erik: Alles over koffie https://example.com/koffie?utm_source=irc") {
+		test.Error("Failed urls with", texts)
+	}
+	if texts := lines("!urls HARM", 2); !strings.HasSuffix(texts[0], "harm: https://example.org/water") ||
+		!strings.HasSuffix(texts[1], "harm: https://example.org/thee") {
+		test.Error("Failed urls by nick with", texts)
+	}
+	if texts := lines("!urls erik koffie", 2); !strings.Contains(texts[0], "#boven") || !strings.Contains(texts[1], "Alles over koffie") {
+		test.Error("Failed urls by nick and text with", texts)
+	}
+	if texts := lines("!urls thee", 1); !strings.HasSuffix(texts[0], "harm: https://example.org/thee") {
+		test.Error("Failed urls by text with", texts)
+	}
+	if texts := lines("!urls harm koffie", 1); texts[0] != b.text("#bottest", "urls.none", nil) {
+		test.Error("Found links in another channel with", texts)
+	}
+
+	// Not in private, where anyone could ask about any channel
+	b.Reader = bufio.NewReader(strings.NewReader(":harm!somewhere PRIVMSG TestBot :!urls erik\n"))
+	go b.ChatLine()
+	if out := <-b.Output; out.String() != "PRIVMSG harm :"+b.text("harm", "urls.private", nil)+"\n" {
+		test.Error("Failed private urls with", out.String())
+	}
+
+	// It's all still there after a restart, and can be exported
+	b.urls.flush()
+	b = initDummyBot()
+	b.UrlLog = filepath.Join(dir, "urls.json")
+	b.UrlExport = filepath.Join(dir, "urls.csv")
+	b.LoadUrlLog()
+	b.Admins = []string{"harm!*@home"}
+	export := func(hostmask, channel string) {
+		b.Reader = bufio.NewReader(strings.NewReader(":" + hostmask + " PRIVMSG " + channel + " :!urlexport\n"))
+		b.ChatLine()
+	}
+	// Only admins, and only in private
+	export("harm!harm@home", "#bottest")
+	export("someone!someone@elsewhere", "TestBot")
+	if _, err := os.Stat(b.UrlExport); err == nil {
+		test.Error("Exported when not asked by an admin in private")
+	}
+	b.Reader = bufio.NewReader(strings.NewReader(":harm!harm@home PRIVMSG TestBot :!urlexport\n"))
+	go b.ChatLine()
+	if out := <-b.Output; out.String() != "PRIVMSG harm :6 links geschreven naar "+b.UrlExport+".\n" {
+		test.Error("Failed export with", out.String())
+	}
+	csv, _ := ioutil.ReadFile(b.UrlExport)
+	if rows := strings.Split(strings.TrimSpace(string(csv)), "\n"); len(rows) != 7 || rows[0] != "time,channel,sender,url,title" ||
+		!strings.HasSuffix(rows[1], ",#bottest,erik,https://example.com/koffie?utm_source=irc,Alles over koffie") {
+		test.Error("Exported", rows)
 	}
 }
@@ -0,0 +1,79 @@
+package eppobot
+
+import (
+	"../catalog"
+	"../p2000"
+	"log"
+)
+
+const (
+	//How many messages !sikknel shows at most
+	p2000MaxResults = 5
+	//Unless asked otherwise, only the urgent ones, as it always was
+	p2000DefaultPriority = 2
+)
+
+//Set up where P2000 messages come from
+func (b *QuoteBot) LoadP2000() {
+	source, err := p2000.New(b.P2000)
+	if err != nil {
+		log.Println("Can't read P2000 messages:", err)
+		return
+	}
+	b.p2k = source
+}
+
+//"!sikknel" shows the latest urgent P2000 message, "!sikknel 3" the latest
+//three, and "!sikknel 3 ambulance groningen" the latest three for the
+//ambulance in Groningen. A priority ("p3") shows less urgent ones too.
+func reportP2000(b *QuoteBot, in *IrcMessage, args *Args) {
+	n := args.Int("n", 1)
+	if n < 1 {
+		n = 1
+	}
+	if n > p2000MaxResults {
+		n = p2000MaxResults
+	}
+	filter := p2000.ParseFilter(args.Get("filter"))
+	if filter.MaxPriority == 0 {
+		filter.MaxPriority = p2000DefaultPriority
+	}
+	//Sites can be slow, so this gets a goroutine of its own
+	go b.ReportP2k(in.Channel, n, filter)
+}
+
+func (b *QuoteBot) ReportP2k(channel string, n int, filter p2000.Filter) {
+	say := func(key string, params catalog.Params) {
+		b.Output <- &IrcMessage{
+			Channel: channel,
+			Text:    b.text(channel, key, params),
+		}
+	}
+	if b.p2k == nil {
+		say("p2000.failed", nil)
+		return
+	}
+	messages, err := b.p2k.Messages()
+	if err != nil {
+		log.Println("Can't read P2000 messages:", err)
+		say("p2000.failed", nil)
+		return
+	}
+	var found []p2000.Message
+	for _, m := range messages {
+		if len(found) < n && filter.Match(m) {
+			found = append(found, m)
+		}
+	}
+	if len(found) == 0 {
+		say("p2000.none", nil)
+		return
+	}
+	for _, m := range found {
+		if m.Time.IsZero() {
+			say("p2000.message", catalog.Params{"text": m.Text})
+			continue
+		}
+		say("p2000.message.time", catalog.Params{"time": m.Time.Format("15:04"), "text": m.Text})
+	}
+}
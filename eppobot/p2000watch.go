@@ -0,0 +1,223 @@
+package eppobot
+
+import (
+	"../catalog"
+	"../p2000"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultP2000Seen = "p2000seen.json"
+	//How often we look, in minutes, and which alerts count, unless the config
+	//says otherwise
+	defaultP2000Interval = 2
+	//Alerts older than this are old news, also when we haven't seen them yet
+	p2000MaxAge = 30 * time.Minute
+	//How long we remember an alert we've seen
+	p2000SeenTTL = 24 * time.Hour
+)
+
+//Which P2000 alerts to announce without being asked, as in config.json
+type P2000Watch struct {
+	//Where to announce them; nothing is watched without it
+	Channel string
+	//How often to look, in minutes
+	Interval int
+	//Only alerts this urgent or more; P2 if left empty
+	MaxPriority int
+	//Alerts with any of these are announced. A postcode may leave out the
+	//letters to mean the whole area.
+	Postcodes []string
+	Streets   []string
+	Capcodes  []string
+	//When not to announce anything, such as "23:00-07:00"
+	Quiet string
+	//Where the alerts already announced are kept, p2000seen.json if empty
+	File string
+}
+
+//The alerts we've seen, so each is announced once, also across restarts,
+//and the config as read once at the start
+type p2000Watcher struct {
+	lock sync.Mutex
+	file string
+	seen map[string]time.Time
+	//Quiet hours in minutes since midnight, if there are any
+	quiet    bool
+	from, to int
+	streets  []*regexp.Regexp
+}
+
+var postcode = regexp.MustCompile(`\b(\d{4}) ?([A-Za-z]{2})?\b`)
+
+//Poll the P2000 sources for as long as the bot runs, announcing the alerts
+//the config asks for
+func (b *QuoteBot) WatchP2000() {
+	if b.P2000Watch.Channel == "" {
+		return
+	}
+	b.loadP2000Watch()
+	interval := b.P2000Watch.Interval
+	if interval <= 0 {
+		interval = defaultP2000Interval
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+	defer ticker.Stop()
+	for {
+		b.checkP2000(time.Now())
+		<-ticker.C
+	}
+}
+
+func (b *QuoteBot) loadP2000Watch() {
+	w := &b.p2kSeen
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.quiet = false
+	if quiet := b.P2000Watch.Quiet; quiet != "" {
+		var fromHour, fromMinute, toHour, toMinute int
+		if _, err := fmt.Sscanf(quiet, "%d:%d-%d:%d", &fromHour, &fromMinute, &toHour, &toMinute); err != nil {
+			log.Printf("Quiet hours %q are not like 23:00-07:00\n", quiet)
+		} else {
+			w.quiet, w.from, w.to = true, fromHour*60+fromMinute, toHour*60+toMinute
+		}
+	}
+	w.streets = nil
+	for _, street := range b.P2000Watch.Streets {
+		w.streets = append(w.streets, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(street)+`\b`))
+	}
+	w.file = b.P2000Watch.File
+	if w.file == "" {
+		w.file = defaultP2000Seen
+	}
+	w.seen = make(map[string]time.Time)
+	jsonBlob, err := ioutil.ReadFile(w.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Can't read the P2000 alerts seen,", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(jsonBlob, &w.seen); err != nil {
+		log.Println("Can't parse the P2000 alerts seen,", err)
+	}
+}
+
+//Call with the lock held
+func (w *p2000Watcher) save() {
+	if w.file == "" {
+		return
+	}
+	jsonBlob, err := json.Marshal(w.seen)
+	if err == nil {
+		err = ioutil.WriteFile(w.file, jsonBlob, 0644)
+	}
+	if err != nil {
+		log.Println("Can't save the P2000 alerts seen,", err)
+	}
+}
+
+//Look for new alerts and announce those that are ours, oldest first. In quiet
+//hours they're only noted, so they don't all come at once in the morning.
+func (b *QuoteBot) checkP2000(now time.Time) {
+	if b.p2k == nil {
+		return
+	}
+	messages, err := b.p2k.Messages()
+	if err != nil {
+		log.Println("Can't read P2000 messages:", err)
+		return
+	}
+	w := &b.p2kSeen
+	w.lock.Lock()
+	quiet := w.quietHours(now)
+	if w.seen == nil {
+		w.seen = make(map[string]time.Time)
+	}
+	var announce []p2000.Message
+	for i := len(messages) - 1; i >= 0; i-- {
+		m := messages[i]
+		if _, seen := w.seen[m.Id()]; seen {
+			continue
+		}
+		w.seen[m.Id()] = now
+		if !quiet && b.P2000Watch.matches(m, w.streets) && (m.Time.IsZero() || now.Sub(m.Time) <= p2000MaxAge) {
+			announce = append(announce, m)
+		}
+	}
+	for id, seen := range w.seen {
+		if now.Sub(seen) > p2000SeenTTL {
+			delete(w.seen, id)
+		}
+	}
+	w.save()
+	w.lock.Unlock()
+
+	channel := b.P2000Watch.Channel
+	for _, m := range announce {
+		when := m.Time
+		if when.IsZero() {
+			when = now
+		}
+		b.Output <- &IrcMessage{
+			Channel: channel,
+			Text:    b.text(channel, "p2000.alert", catalog.Params{"time": when.In(p2000.DutchTime).Format("15:04"), "text": m.Text}),
+		}
+	}
+}
+
+//Whether an alert is urgent enough and in the area we watch, with the streets
+//as patterns
+func (watch P2000Watch) matches(m p2000.Message, streets []*regexp.Regexp) bool {
+	maxPriority := watch.MaxPriority
+	if maxPriority <= 0 {
+		maxPriority = p2000DefaultPriority
+	}
+	if m.Priority == 0 || m.Priority > maxPriority {
+		return false
+	}
+	for _, found := range postcode.FindAllStringSubmatch(m.Text, -1) {
+		for _, want := range watch.Postcodes {
+			want = strings.ToUpper(strings.Replace(want, " ", "", -1))
+			if want == found[1] || want == found[1]+strings.ToUpper(found[2]) {
+				return true
+			}
+		}
+	}
+	for _, street := range streets {
+		if street.MatchString(m.Text) {
+			return true
+		}
+	}
+	for _, want := range watch.Capcodes {
+		for _, code := range m.Capcodes {
+			if code == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//Whether it's quiet at the time, by the Dutch clock, whatever the host's is.
+//Call with the lock held.
+func (w *p2000Watcher) quietHours(now time.Time) bool {
+	if !w.quiet {
+		return false
+	}
+	now = now.In(p2000.DutchTime)
+	minute := now.Hour()*60 + now.Minute()
+	if w.from <= w.to {
+		return w.from <= minute && minute < w.to
+	}
+	//Over midnight
+	return minute >= w.from || minute < w.to
+}
@@ -0,0 +1,151 @@
+package eppobot
+
+import (
+	"../catalog"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//The persona is made of triggers: things the bot says when it sees certain
+//messages. They are read from a JSON file, which might look like
+//
+//	{"Triggers": [
+//		{"Pattern": "(?i)^koffie", "Replies": ["Ik neem thee, {sender}."]},
+//		{"Command": "jarig", "Replies": ["Hoera voor {args}!"]},
+//		{"Addressed": true, "Pattern": "(?i)hoe gaat het", "Message": "generic"}
+//	]}
+//
+//A trigger matches either a Pattern against the message, or a Command like
+//"!jarig". Addressed triggers only match if the message is directed at the
+//bot, and the pattern is matched against what comes after the name. The bot
+//replies with one of the Replies, or with a line from the message catalogue.
+//Both can use {sender}, {channel}, {nick}, {args} (for commands), {1}, {2}...
+//(for groups in the pattern) and {quote.name} and {quote.text} of a random
+//quote. A reply that comes out empty is not sent, which is a way to silence
+//one of the default triggers.
+type Persona struct {
+	Triggers []Trigger
+}
+
+type Trigger struct {
+	Pattern   string
+	Command   string
+	Addressed bool
+	Replies   []string
+	Message   string
+}
+
+//The triggers that are always there, after those in the persona file
+var defaultTriggers = []Trigger{
+	Trigger{Command: "college", Message: "college"},
+	Trigger{Command: "collage", Message: "collage"},
+	Trigger{Pattern: "^gang", Replies: []string{"GANG!!!"}},
+	Trigger{Pattern: "(?i)^la+[sz][eo0]r", Replies: []string{"LAZERS!"}},
+	Trigger{Addressed: true, Message: "generic"},
+}
+
+func LoadPersona(file string) (*Persona, error) {
+	jsonBlob, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var p Persona
+	if err := json.Unmarshal(jsonBlob, &p); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", file, err)
+	}
+	return &p, nil
+}
+
+//(Re)load the persona file. Its triggers are tried after the built-in
+//handlers. If the file is broken, the bot keeps the persona it had.
+func (b *QuoteBot) LoadPersona() {
+	var triggers []Trigger
+	if b.Persona != "" {
+		p, err := LoadPersona(b.Persona)
+		if err != nil {
+			log.Printf("Error loading persona from %s: %s\n", b.Persona, err)
+			if b.triggers != nil {
+				return
+			}
+		} else {
+			triggers = p.Triggers
+		}
+	}
+	triggers = append(triggers, defaultTriggers...)
+
+	handlers := make([]ActionHandler, 0, len(triggers))
+	for i, t := range triggers {
+		ah, err := t.handler()
+		if err != nil {
+			log.Printf("Error in trigger %d of persona %s: %s\n", i+1, b.Persona, err)
+			continue
+		}
+		handlers = append(handlers, ah)
+	}
+	b.triggers = handlers
+}
+
+func (t Trigger) handler() (ActionHandler, error) {
+	if len(t.Replies) == 0 && t.Message == "" {
+		return ActionHandler{}, fmt.Errorf("trigger without replies")
+	}
+	reply := func(b *QuoteBot, in *IrcMessage, params catalog.Params) {
+		params["sender"] = in.Sender
+		params["channel"] = in.Channel
+		params["nick"] = b.Nickname
+		var text string
+		if t.Message != "" {
+			text = b.text(in.Channel, t.Message, nil)
+		} else {
+			text = t.Replies[0]
+			if len(t.Replies) > 1 {
+				text = t.Replies[rand.Intn(len(t.Replies))]
+			}
+		}
+		//Only pick a quote if we need one
+		if strings.Contains(text, "{quote.") && len(b.Qdb) > 0 {
+			q := b.Qdb[rand.Intn(len(b.Qdb))]
+			params["quote.name"] = q.Name
+			params["quote.text"] = q.Text
+		}
+		text = catalog.Fill(text, params)
+		if text == "" {
+			return
+		}
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    text,
+		}
+	}
+
+	if t.Command != "" {
+		if t.Pattern != "" {
+			return ActionHandler{}, fmt.Errorf("trigger has both a command and a pattern")
+		}
+		return command(t.Command, "[<args...>]", func(b *QuoteBot, in *IrcMessage, args *Args) {
+			reply(b, in, catalog.Params{"args": args.Get("args")})
+		}), nil
+	}
+
+	re, err := regexp.Compile(t.Pattern)
+	if err != nil {
+		return ActionHandler{}, err
+	}
+	return ActionHandler{
+		Regexp:    re,
+		Addressed: t.Addressed,
+		Handler: func(b *QuoteBot, in *IrcMessage, submatches []string) {
+			params := make(catalog.Params)
+			for i, s := range submatches {
+				params[strconv.Itoa(i)] = s
+			}
+			reply(b, in, params)
+		},
+	}, nil
+}
@@ -0,0 +1,126 @@
+package eppobot
+
+import (
+	"../twitterbot"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//How long a post waits for "!tweet ja" before it is forgotten
+const postTimeout = 10 * time.Minute
+
+//Posts that wait for whoever asked for them to say yes, by sender and command,
+//so "!toot ja" doesn't send what was meant as a tweet. Only used from the
+//goroutine that reads chat, so it needs no locking.
+type pendingPosts map[string]pendingPost
+
+type pendingPost struct {
+	Text  string
+	Since time.Time
+}
+
+//Whether the sender may post as the bot. Tweeters are host masks, such as
+//"erik!*@erik.example.com", as anyone can take a nickname.
+func (b *QuoteBot) mayPost(in *IrcMessage) bool {
+	return anyMask(b.Tweeters, in, "Tweeter")
+}
+
+//Whether the sender fits one of the host masks; what they are for is only
+//used to log those that aren't masks
+func anyMask(masks []string, in *IrcMessage, what string) bool {
+	for _, mask := range masks {
+		if !strings.Contains(mask, "!") || !strings.Contains(mask, "@") {
+			log.Printf("%s %q is no host mask like erik!*@erik.example.com, skipping it\n", what, mask)
+			continue
+		}
+		if matchMask(mask, in.Hostmask) {
+			return true
+		}
+	}
+	return false
+}
+
+//Whether the host mask, with * for anything and ? for any one character,
+//fits nick!user@host
+func matchMask(mask, hostmask string) bool {
+	pattern := regexp.QuoteMeta(mask)
+	pattern = strings.Replace(pattern, `\*`, ".*", -1)
+	pattern = strings.Replace(pattern, `\?`, ".", -1)
+	return regexp.MustCompile("(?i)^" + pattern + "$").MatchString(hostmask)
+}
+
+//"!tweet tekst" shows what would be posted; "!tweet ja" posts it and
+//"!tweet nee" forgets it. !toot does the same.
+func postTweet(b *QuoteBot, in *IrcMessage, args *Args) {
+	post(b, in, args, "tweet")
+}
+
+func postToot(b *QuoteBot, in *IrcMessage, args *Args) {
+	post(b, in, args, "toot")
+}
+
+//Post as the bot, for the command with the name given
+func post(b *QuoteBot, in *IrcMessage, args *Args, name string) {
+	if !b.mayPost(in) {
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    b.text(in.Channel, "tweet.denied", nil),
+		}
+		return
+	}
+	if b.pending == nil {
+		b.pending = make(pendingPosts)
+	}
+	key := strings.ToLower(in.Sender) + " " + name
+	post, waiting := b.pending[key]
+	if waiting && time.Since(post.Since) > postTimeout {
+		delete(b.pending, key)
+		waiting = false
+	}
+
+	text := args.Get("tekst")
+	switch strings.ToLower(text) {
+	case "ja", "yes":
+		if !waiting {
+			break
+		}
+		delete(b.pending, key)
+		b.requestTwitter(in.Channel, twitterbot.Request{
+			Command: twitterbot.CTL_POST,
+			Arg:     post.Text,
+			Prefix:  b.commandPrefixes(in.Channel)[0],
+			Name:    name,
+		})
+		return
+	case "nee", "no":
+		if !waiting {
+			break
+		}
+		delete(b.pending, key)
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    b.text(in.Channel, "tweet.cancelled", nil),
+		}
+		return
+	}
+	b.pending[key] = pendingPost{Text: text, Since: time.Now()}
+	b.requestTwitter(in.Channel, twitterbot.Request{
+		Command: twitterbot.CTL_PREVIEW_POST,
+		Arg:     text,
+		Prefix:  b.commandPrefixes(in.Channel)[0],
+		Name:    name,
+	})
+}
+
+func deleteTweet(b *QuoteBot, in *IrcMessage, args *Args) {
+	if !b.mayPost(in) {
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    b.text(in.Channel, "tweet.denied", nil),
+		}
+		return
+	}
+	b.askTwitter(in, twitterbot.CTL_DELETE_POST, "")
+}
@@ -0,0 +1,460 @@
+package eppobot
+
+import (
+	"../catalog"
+	"bytes"
+	"code.google.com/p/go.net/html"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf16"
+)
+
+const (
+	//How long we wait for a page, all redirects included
+	previewTimeout = 5 * time.Second
+	//How much of a page we read at most
+	previewMaxBytes     = 1 << 20
+	previewMaxRedirects = 5
+	//How long we remember what a link was
+	previewTTL       = time.Hour
+	previewCacheSize = 500
+	//How long the line with a preview may get
+	previewMaxLength = 300
+	//How many links of one message get a preview
+	previewMaxLinks = 3
+)
+
+//What a link turned out to be. Kind is "page", "embed", "image" or "pdf".
+type linkPreview struct {
+	Kind        string
+	Title       string
+	Description string
+	//For embeds
+	Author   string
+	Provider string
+	//For images
+	Format        string
+	Width, Height int
+}
+
+//Fetches links and remembers what they were. Previews are made in their own
+//goroutines, so this has a lock of its own.
+type previewer struct {
+	lock  sync.Mutex
+	cache map[string]cachedPreview
+	//Whether addresses on the local network may be fetched; only when testing
+	allowLocal bool
+}
+
+type cachedPreview struct {
+	Preview *linkPreview
+	Time    time.Time
+}
+
+//Whether links in the channel get a preview
+func (b *QuoteBot) previewsOn(channel string) bool {
+	if c, ok := b.channelConfig(channel); ok && c.Previews != nil {
+		return *c.Previews
+	}
+	return b.Previews
+}
+
+//Whether the host, or a domain it is in, is on the blocklist
+func (b *QuoteBot) previewBlocked(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range b.PreviewBlocklist {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+//The links in a message worth a preview
+func findLinks(text string) []string {
+	var links []string
+	for _, word := range strings.Fields(text) {
+		word = strings.TrimRight(word, ".,;:!?)>'\"")
+		word = strings.TrimLeft(word, "(<'\"")
+		if !strings.HasPrefix(word, "http://") && !strings.HasPrefix(word, "https://") {
+			continue
+		}
+		if u, err := url.Parse(word); err != nil || u.Host == "" {
+			continue
+		}
+		links = append(links, word)
+		if len(links) == previewMaxLinks {
+			break
+		}
+	}
+	return links
+}
+
+//Say what the links in a message are, one line each. In channels, the
+//titles go in the link log, also where previews are off.
+func (b *QuoteBot) previewLinks(in *IrcMessage, links []string) {
+	logged := strings.HasPrefix(in.Channel, "#")
+	if !b.previewsOn(in.Channel) && !logged {
+		return
+	}
+	for _, link := range links {
+		preview := b.preview(link)
+		if preview == nil {
+			continue
+		}
+		if logged && preview.Title != "" {
+			b.logTitle(in.Channel, link, preview.Title)
+		}
+		if !b.previewsOn(in.Channel) {
+			continue
+		}
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    b.previewText(in.Channel, preview),
+		}
+	}
+}
+
+//What a link is, from the cache if we saw it lately. Nil if there is nothing
+//to say about it.
+func (b *QuoteBot) preview(link string) *linkPreview {
+	p := &b.previews
+	p.lock.Lock()
+	if cached, ok := p.cache[link]; ok && time.Since(cached.Time) < previewTTL {
+		p.lock.Unlock()
+		return cached.Preview
+	}
+	p.lock.Unlock()
+
+	preview, err := b.fetchPreview(link)
+	if err != nil {
+		log.Println("Can't preview", link+":", err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.cache == nil {
+		p.cache = make(map[string]cachedPreview)
+	}
+	if len(p.cache) >= previewCacheSize {
+		p.evict()
+	}
+	p.cache[link] = cachedPreview{preview, time.Now()}
+	return preview
+}
+
+//Make room, by forgetting what is old, or else the oldest. Call with the
+//lock held.
+func (p *previewer) evict() {
+	oldest := ""
+	for link, cached := range p.cache {
+		if time.Since(cached.Time) >= previewTTL {
+			delete(p.cache, link)
+		} else if oldest == "" || cached.Time.Before(p.cache[oldest].Time) {
+			oldest = link
+		}
+	}
+	if len(p.cache) >= previewCacheSize {
+		delete(p.cache, oldest)
+	}
+}
+
+//A client that keeps to the limits, doesn't follow redirects to blocked
+//domains and stays off the local network
+func (b *QuoteBot) previewClient() *http.Client {
+	dialer := &net.Dialer{Timeout: previewTimeout}
+	if !b.previews.allowLocal {
+		dialer.Control = refuseLocal
+	}
+	return &http.Client{
+		Timeout:   previewTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if len(via) >= previewMaxRedirects {
+				return errors.New("too many redirects")
+			}
+			if b.previewBlocked(r.URL.Hostname()) {
+				return errors.New("redirected to a blocked domain")
+			}
+			return nil
+		},
+	}
+}
+
+//Whatever a link says, the bot is no way into the network it runs in
+func refuseLocal(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return fmt.Errorf("won't fetch from %s", host)
+	}
+	return nil
+}
+
+func (b *QuoteBot) fetchPreview(link string) (*linkPreview, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || b.previewBlocked(u.Hostname()) {
+		return nil, nil
+	}
+	client := b.previewClient()
+	request, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", "Mozilla/5.0 (compatible; JanEppo)")
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got %s", response.Status)
+	}
+	body := io.LimitReader(response.Body, previewMaxBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return b.pagePreview(client, response.Request.URL, body)
+	case strings.HasPrefix(mediaType, "image/"):
+		config, format, err := image.DecodeConfig(body)
+		if err != nil {
+			return nil, err
+		}
+		return &linkPreview{Kind: "image", Format: strings.ToUpper(format), Width: config.Width, Height: config.Height}, nil
+	case mediaType == "application/pdf":
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		return &linkPreview{Kind: "pdf", Title: pdfTitle(data)}, nil
+	}
+	return nil, nil
+}
+
+//What a page says it is: what its oEmbed says, or else its OpenGraph title
+//and description, or else its title
+func (b *QuoteBot) pagePreview(client *http.Client, page *url.URL, body io.Reader) (*linkPreview, error) {
+	doc, err := html.Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	var title, ogTitle, description, ogDescription, embed string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			attrs := make(map[string]string)
+			for _, attr := range n.Attr {
+				attrs[strings.ToLower(attr.Key)] = attr.Val
+			}
+			switch {
+			case n.Data == "title" && title == "" && n.FirstChild != nil:
+				title = n.FirstChild.Data
+			case n.Data == "meta" && attrs["property"] == "og:title":
+				ogTitle = attrs["content"]
+			case n.Data == "meta" && attrs["property"] == "og:description":
+				ogDescription = attrs["content"]
+			case n.Data == "meta" && strings.ToLower(attrs["name"]) == "description":
+				description = attrs["content"]
+			case n.Data == "link" && attrs["type"] == "application/json+oembed" && embed == "":
+				embed = attrs["href"]
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	if embed != "" {
+		preview, err := b.embedPreview(client, page, embed)
+		if err == nil {
+			return preview, nil
+		}
+		log.Println("Can't read oEmbed of", page.String()+":", err)
+	}
+	if ogTitle != "" {
+		title = ogTitle
+	}
+	if ogDescription != "" {
+		description = ogDescription
+	}
+	title, description = cleanText(title), cleanText(description)
+	if title == "" {
+		return nil, nil
+	}
+	return &linkPreview{Kind: "page", Title: title, Description: description}, nil
+}
+
+//The page says where its oEmbed is, so that could be anywhere, blocked or not
+func (b *QuoteBot) embedPreview(client *http.Client, page *url.URL, link string) (*linkPreview, error) {
+	u, err := page.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || b.previewBlocked(u.Hostname()) {
+		return nil, errors.New("oEmbed on a blocked domain")
+	}
+	response, err := client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got %s", response.Status)
+	}
+	var embed struct {
+		Title         string
+		Author_Name   string
+		Provider_Name string
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, previewMaxBytes)).Decode(&embed); err != nil {
+		return nil, err
+	}
+	if embed.Title = cleanText(embed.Title); embed.Title == "" {
+		return nil, errors.New("no title")
+	}
+	return &linkPreview{
+		Kind:     "embed",
+		Title:    embed.Title,
+		Author:   cleanText(embed.Author_Name),
+		Provider: cleanText(embed.Provider_Name),
+	}, nil
+}
+
+//One line of valid text, however the page had it. Control characters go, or
+//IRC would take them for CTCP or colours.
+func cleanText(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r < 0x20 && !unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(text, ""))
+	return strings.Join(strings.Fields(text), " ")
+}
+
+var pdfTitlePattern = regexp.MustCompile(`/Title\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+
+//The title in the document information of a PDF, if we got that far
+func pdfTitle(data []byte) string {
+	match := pdfTitlePattern.FindSubmatch(data)
+	if match == nil {
+		return ""
+	}
+	var raw []byte
+	if value := match[1]; value[0] == '<' {
+		raw, _ = hex.DecodeString(strings.Join(strings.Fields(string(value[1:len(value)-1])), ""))
+	} else {
+		raw = pdfUnescape(value[1 : len(value)-1])
+	}
+	//Text strings are UTF-16 or UTF-8 if they start with a byte order mark,
+	//and else something close enough to Latin-1
+	switch {
+	case bytes.HasPrefix(raw, []byte{0xfe, 0xff}):
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		return cleanText(string(utf16.Decode(units)))
+	case bytes.HasPrefix(raw, []byte{0xef, 0xbb, 0xbf}):
+		return cleanText(string(raw[3:]))
+	}
+	runes := make([]rune, len(raw))
+	for i, c := range raw {
+		runes[i] = rune(c)
+	}
+	return cleanText(string(runes))
+}
+
+//The bytes of a literal string in a PDF, without its backslashes
+func pdfUnescape(value []byte) []byte {
+	var out []byte
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			out = append(out, value[i])
+			continue
+		}
+		i++
+		switch c := value[i]; {
+		case c == 'n':
+			out = append(out, '\n')
+		case c == 'r':
+			out = append(out, '\r')
+		case c == 't':
+			out = append(out, '\t')
+		case c >= '0' && c <= '7':
+			octal := 0
+			for j := 0; j < 3 && i < len(value) && value[i] >= '0' && value[i] <= '7'; j++ {
+				octal = octal*8 + int(value[i]-'0')
+				i++
+			}
+			i--
+			out = append(out, byte(octal))
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+//The line that says what a link is
+func (b *QuoteBot) previewText(channel string, p *linkPreview) string {
+	var text string
+	switch p.Kind {
+	case "image":
+		text = b.text(channel, "link.image", catalog.Params{
+			"format": p.Format, "width": fmt.Sprint(p.Width), "height": fmt.Sprint(p.Height),
+		})
+	case "pdf":
+		if p.Title == "" {
+			return b.text(channel, "link.pdf.untitled", nil)
+		}
+		text = b.text(channel, "link.pdf", catalog.Params{"title": p.Title})
+	case "embed":
+		key := "link.embed"
+		if p.Author == "" {
+			key = "link.page"
+		}
+		text = b.text(channel, key, catalog.Params{"title": p.Title, "author": p.Author})
+		if p.Provider != "" {
+			text += " (" + p.Provider + ")"
+		}
+	default:
+		key := "link.page"
+		if p.Description != "" {
+			key = "link.page.description"
+		}
+		text = b.text(channel, key, catalog.Params{"title": p.Title, "description": p.Description})
+	}
+	if len(text) > previewMaxLength {
+		text = strings.ToValidUTF8(text[:previewMaxLength-3], "") + "..."
+	}
+	return text
+}
@@ -0,0 +1,128 @@
+package eppobot
+
+import (
+	"../catalog"
+	"strings"
+	"time"
+)
+
+//How long, in seconds, before a command may be used again by the same user,
+//and by anyone in the same channel. Zero means no limit.
+type Cooldown struct {
+	User    int
+	Channel int
+}
+
+//Cooldowns for commands that are not in Config.Cooldowns
+var defaultCooldowns = map[string]Cooldown{
+	"sl":      Cooldown{User: 60, Channel: 20},
+	"sikknel": Cooldown{User: 60, Channel: 30},
+}
+
+//Keeps track of when commands may be used again. Only used from the goroutine
+//that reads chat, so it needs no locking.
+type rateLimiter struct {
+	until   map[string]time.Time
+	refused map[string]bool
+}
+
+func (b *QuoteBot) cooldown(channel, command string) Cooldown {
+	if c, ok := b.channelConfig(channel); ok {
+		if cd, ok := c.Cooldowns[command]; ok {
+			return cd
+		}
+	}
+	if cd, ok := b.Cooldowns[command]; ok {
+		return cd
+	}
+	return defaultCooldowns[command]
+}
+
+//Tells whether the sender may use command now, and registers the use if so.
+//The second result is the number of seconds to wait otherwise.
+func (b *QuoteBot) allowCommand(in *IrcMessage, command string, now time.Time) (bool, int) {
+	if wait := b.commandWait(in, command, now); wait > 0 {
+		return false, wait
+	}
+	b.useCommand(in, command, now)
+	return true, 0
+}
+
+//The number of seconds before the sender may use command, zero if they may
+//now
+func (b *QuoteBot) commandWait(in *IrcMessage, command string, now time.Time) int {
+	wait := time.Duration(0)
+	for _, key := range limitKeys(in, command) {
+		if left := b.limiter.until[key].Sub(now); left > wait {
+			wait = left
+		}
+	}
+	return int((wait + time.Second - 1) / time.Second)
+}
+
+//Starts the cooldown of command for the sender and their channel. Uses that
+//have run out are forgotten here too, so the maps don't keep growing.
+func (b *QuoteBot) useCommand(in *IrcMessage, command string, now time.Time) {
+	cd := b.cooldown(in.Channel, command)
+	if cd.User == 0 && cd.Channel == 0 {
+		return
+	}
+	if b.limiter.until == nil {
+		b.limiter.until = make(map[string]time.Time)
+		b.limiter.refused = make(map[string]bool)
+	}
+	for key, until := range b.limiter.until {
+		if !until.After(now) {
+			delete(b.limiter.until, key)
+			delete(b.limiter.refused, key)
+		}
+	}
+	keys := limitKeys(in, command)
+	if cd.User > 0 {
+		b.limiter.until[keys[0]] = now.Add(time.Duration(cd.User) * time.Second)
+	}
+	if cd.Channel > 0 && len(keys) > 1 {
+		b.limiter.until[keys[1]] = now.Add(time.Duration(cd.Channel) * time.Second)
+	}
+	delete(b.limiter.refused, keys[0])
+}
+
+//The sender's key, followed by the channel's unless it's a private message
+func limitKeys(in *IrcMessage, command string) []string {
+	keys := []string{command + " " + strings.ToLower(in.Sender)}
+	if in.Channel != in.Sender {
+		keys = append(keys, command+" "+strings.ToLower(in.Channel))
+	}
+	return keys
+}
+
+//Runs a command handler unless the sender has to wait. The first time they
+//are refused they hear about it, after that they are ignored until the
+//cooldown has passed. A command they got wrong doesn't count as a use.
+func (b *QuoteBot) runLimited(ah ActionHandler, in *IrcMessage, matches []string) {
+	now := time.Now()
+	wait := b.commandWait(in, ah.Command, now)
+	if wait == 0 {
+		if ah.Args != nil {
+			if _, err := ah.Args.Parse(matches[1]); err != nil {
+				ah.Handler(b, in, matches)
+				return
+			}
+		}
+		b.useCommand(in, ah.Command, now)
+		ah.Handler(b, in, matches)
+		return
+	}
+	userKey := limitKeys(in, ah.Command)[0]
+	if b.limiter.refused[userKey] {
+		return
+	}
+	b.limiter.refused[userKey] = true
+	b.Output <- &IrcMessage{
+		Channel: in.Channel,
+		Text: b.text(in.Channel, "cooldown", catalog.Params{
+			"sender":  in.Sender,
+			"seconds": wait,
+		}),
+	}
+}
@@ -0,0 +1,361 @@
+package eppobot
+
+import (
+	"encoding/json"
+	"fmt"
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Scripts are small Starlark (a Python dialect) programs in the scripts
+//directory, that add commands and triggers to the bot. A script might be
+//
+//	def dobbel(msg):
+//		zijden = int(msg.args.get("zijden", "6"))
+//		worpen = get("worpen", 0) + 1
+//		put("worpen", worpen)
+//		reply("%s gooit %d (worp %d)" % (msg.sender, randint(1, zijden), worpen))
+//
+//	command("dobbel", dobbel, "[<zijden:int>]")
+//
+//Scripts can only reach the outside world through these builtins:
+//
+//	command(name, fn, usage="")  call fn(msg) for "!name", see ArgSpec for usage
+//	trigger(pattern, fn)         call fn(msg) for messages matching pattern
+//	reply(text)                  say text in the channel the message came from
+//	tell(text)                   say text to the sender, privately; together
+//	                             with reply at most a few lines per message
+//	quotes(name="", text="")     list of (name, text) of matching quotes
+//	get(key, default=None)       read from this script's saved state
+//	put(key, value)              write to it; values must be valid JSON
+//	randint(a, b)                random number from a up to and including b
+//	now()                        the time in seconds since 1970
+//
+//msg has the fields text, sender, channel, args (a dict of the arguments)
+//and groups (the groups matched by a trigger's pattern).
+//
+//Changed scripts are picked up by themselves; state is kept in state.json in
+//the scripts directory.
+
+//How long a script may run for each message, or while it's loaded, and how
+//many lines it may say
+const (
+	scriptSteps    = 1000000
+	scriptTimeout  = 2 * time.Second
+	scriptMaxLines = 5
+	scriptRescan   = 5 * time.Second
+)
+
+type scriptEngine struct {
+	handlers []ActionHandler
+	state    map[string]map[string]json.RawMessage
+	//Modification times of the scripts that were loaded
+	files   map[string]time.Time
+	checked time.Time
+}
+
+//Load all scripts in the scripts directory, replacing the ones there were.
+func (b *QuoteBot) LoadScripts() {
+	b.scripts.handlers = nil
+	b.scripts.files = make(map[string]time.Time)
+	b.scripts.checked = time.Now()
+	if b.Scripts == "" {
+		return
+	}
+	if b.scripts.state == nil {
+		b.scripts.state = b.loadScriptState()
+	}
+
+	names, err := filepath.Glob(filepath.Join(b.Scripts, "*.star"))
+	if err != nil {
+		log.Println("Error listing scripts,", err)
+		return
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if info, err := os.Stat(name); err == nil {
+			b.scripts.files[name] = info.ModTime()
+		}
+		handlers, err := b.loadScript(name)
+		if err != nil {
+			log.Printf("Error loading script %s: %s\n", name, err)
+			continue
+		}
+		b.scripts.handlers = append(b.scripts.handlers, handlers...)
+	}
+	log.Printf("Loaded %d scripts with %d handlers\n", len(names), len(b.scripts.handlers))
+}
+
+//Reload the scripts if any of them has been added, changed or removed.
+//This is cheap enough to do for every message, as long as we don't look
+//too often.
+func (b *QuoteBot) reloadChangedScripts() {
+	if b.Scripts == "" || time.Since(b.scripts.checked) < scriptRescan {
+		return
+	}
+	b.scripts.checked = time.Now()
+	names, _ := filepath.Glob(filepath.Join(b.Scripts, "*.star"))
+	changed := len(names) != len(b.scripts.files)
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil || !info.ModTime().Equal(b.scripts.files[name]) {
+			changed = true
+		}
+	}
+	if changed {
+		log.Println("Scripts have changed, reloading")
+		b.LoadScripts()
+	}
+}
+
+func (b *QuoteBot) loadScript(file string) ([]ActionHandler, error) {
+	script := strings.TrimSuffix(filepath.Base(file), ".star")
+	var handlers []ActionHandler
+
+	commandFn := starlark.NewBuiltin("command", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name, usage string
+		var callback starlark.Callable
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "fn", &callback, "usage?", &usage); err != nil {
+			return nil, err
+		}
+		spec, err := ParseArgSpec(usage)
+		if err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, specCommand(name, spec, func(b *QuoteBot, in *IrcMessage, args *Args) {
+			b.runScript(script, callback, in, args, nil)
+		}))
+		return starlark.None, nil
+	})
+	triggerFn := starlark.NewBuiltin("trigger", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var pattern string
+		var callback starlark.Callable
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pattern", &pattern, "fn", &callback); err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, ActionHandler{
+			Regexp: re,
+			Handler: func(b *QuoteBot, in *IrcMessage, submatches []string) {
+				b.runScript(script, callback, in, nil, submatches)
+			},
+		})
+		return starlark.None, nil
+	})
+
+	thread := b.scriptThread(script, nil)
+	timer := time.AfterFunc(scriptTimeout, func() {
+		thread.Cancel("too slow")
+	})
+	defer timer.Stop()
+	predeclared := b.scriptBuiltins()
+	predeclared["command"] = commandFn
+	predeclared["trigger"] = triggerFn
+	_, err := starlark.ExecFile(thread, file, nil, predeclared)
+	return handlers, err
+}
+
+//Call a function of a script for an incoming message. Whatever goes wrong in
+//there, chat goes on.
+func (b *QuoteBot) runScript(script string, fn starlark.Callable, in *IrcMessage, args *Args, groups []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Script %s crashed: %v\n", script, r)
+		}
+	}()
+	argDict := starlark.NewDict(0)
+	if args != nil {
+		for name, value := range args.values {
+			argDict.SetKey(starlark.String(name), starlark.String(value))
+		}
+		for name, value := range args.flags {
+			argDict.SetKey(starlark.String("--"+name), starlark.String(value))
+		}
+	}
+	groupList := make([]starlark.Value, len(groups))
+	for i, g := range groups {
+		groupList[i] = starlark.String(g)
+	}
+	msg := starlarkstruct.FromStringDict(starlark.String("msg"), starlark.StringDict{
+		"text":    starlark.String(in.Text),
+		"sender":  starlark.String(in.Sender),
+		"channel": starlark.String(in.Channel),
+		"args":    argDict,
+		"groups":  starlark.NewList(groupList),
+	})
+
+	thread := b.scriptThread(script, in)
+	timer := time.AfterFunc(scriptTimeout, func() {
+		thread.Cancel("too slow")
+	})
+	defer timer.Stop()
+	if _, err := starlark.Call(thread, fn, starlark.Tuple{msg}, nil); err != nil {
+		log.Printf("Error in script %s: %s\n", script, err)
+	}
+}
+
+func (b *QuoteBot) scriptThread(script string, in *IrcMessage) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name:  script,
+		Print: func(_ *starlark.Thread, msg string) { log.Printf("Script %s: %s\n", script, msg) },
+		Load: func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+			return nil, fmt.Errorf("scripts cannot load other files")
+		},
+	}
+	thread.SetMaxExecutionSteps(scriptSteps)
+	thread.SetLocal("script", script)
+	if in != nil {
+		thread.SetLocal("message", in)
+	}
+	return thread
+}
+
+//The builtins that are available when the script is loaded as well as when
+//it runs. Those that need a message complain when used during loading.
+func (b *QuoteBot) scriptBuiltins() starlark.StringDict {
+	message := func(thread *starlark.Thread, fn *starlark.Builtin) (*IrcMessage, error) {
+		in, ok := thread.Local("message").(*IrcMessage)
+		if !ok {
+			return nil, fmt.Errorf("%s: only possible in reply to a message", fn.Name())
+		}
+		return in, nil
+	}
+	say := func(private bool) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var text string
+			if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "text", &text); err != nil {
+				return nil, err
+			}
+			in, err := message(thread, fn)
+			if err != nil {
+				return nil, err
+			}
+			said, _ := thread.Local("said").(int)
+			if said >= scriptMaxLines {
+				return nil, fmt.Errorf("%s: no more than %d lines per message", fn.Name(), scriptMaxLines)
+			}
+			thread.SetLocal("said", said+1)
+			target := in.Channel
+			if private {
+				target = in.Sender
+			}
+			//One line at a time, or the server will drop the rest
+			text = strings.Replace(strings.Replace(text, "\r", " ", -1), "\n", " ", -1)
+			b.Output <- &IrcMessage{Channel: target, Text: text}
+			return starlark.None, nil
+		}
+	}
+	state := func(thread *starlark.Thread) map[string]json.RawMessage {
+		script := thread.Local("script").(string)
+		if b.scripts.state == nil {
+			b.scripts.state = make(map[string]map[string]json.RawMessage)
+		}
+		if b.scripts.state[script] == nil {
+			b.scripts.state[script] = make(map[string]json.RawMessage)
+		}
+		return b.scripts.state[script]
+	}
+	encode := starlarkjson.Module.Members["encode"]
+	decode := starlarkjson.Module.Members["decode"]
+
+	return starlark.StringDict{
+		"reply": starlark.NewBuiltin("reply", say(false)),
+		"tell":  starlark.NewBuiltin("tell", say(true)),
+		"quotes": starlark.NewBuiltin("quotes", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name, text string
+			if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name?", &name, "text?", &text); err != nil {
+				return nil, err
+			}
+			fdb := ApplyFilter(b.Qdb, func(q Quote) bool {
+				return CaseInsContains(q.Name, name) && CaseInsContains(q.Text, text)
+			})
+			list := make([]starlark.Value, len(fdb))
+			for i, q := range fdb {
+				list[i] = starlark.Tuple{starlark.String(q.Name), starlark.String(q.Text)}
+			}
+			return starlark.NewList(list), nil
+		}),
+		"get": starlark.NewBuiltin("get", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var key string
+			var def starlark.Value = starlark.None
+			if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "key", &key, "default?", &def); err != nil {
+				return nil, err
+			}
+			raw, ok := state(thread)[key]
+			if !ok {
+				return def, nil
+			}
+			return starlark.Call(thread, decode, starlark.Tuple{starlark.String(raw)}, nil)
+		}),
+		"put": starlark.NewBuiltin("put", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var key string
+			var value starlark.Value
+			if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "key", &key, "value", &value); err != nil {
+				return nil, err
+			}
+			if value == starlark.None {
+				delete(state(thread), key)
+			} else {
+				encoded, err := starlark.Call(thread, encode, starlark.Tuple{value}, nil)
+				if err != nil {
+					return nil, err
+				}
+				state(thread)[key] = json.RawMessage(encoded.(starlark.String))
+			}
+			b.saveScriptState()
+			return starlark.None, nil
+		}),
+		"randint": starlark.NewBuiltin("randint", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var low, high int
+			if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "a", &low, "b", &high); err != nil {
+				return nil, err
+			}
+			if high < low {
+				return nil, fmt.Errorf("%s: empty range", fn.Name())
+			}
+			//In floats, as the span of a wide range doesn't fit an int
+			if float64(high)-float64(low) >= math.MaxInt32 {
+				return nil, fmt.Errorf("%s: range too wide", fn.Name())
+			}
+			return starlark.MakeInt(low + rand.Intn(high-low+1)), nil
+		}),
+		"now": starlark.NewBuiltin("now", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return starlark.MakeInt64(time.Now().Unix()), nil
+		}),
+	}
+}
+
+func (b *QuoteBot) loadScriptState() map[string]map[string]json.RawMessage {
+	state := make(map[string]map[string]json.RawMessage)
+	jsonBlob, err := ioutil.ReadFile(filepath.Join(b.Scripts, "state.json"))
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(jsonBlob, &state); err != nil {
+		log.Println("Error parsing script state,", err)
+	}
+	return state
+}
+
+func (b *QuoteBot) saveScriptState() {
+	jsonBlob, err := json.MarshalIndent(b.scripts.state, "", "\t")
+	if err != nil {
+		log.Println("Error converting script state to JSON:", err)
+		return
+	}
+	ioutil.WriteFile(filepath.Join(b.Scripts, "state.json"), jsonBlob, 0644)
+}
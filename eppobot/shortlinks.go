@@ -0,0 +1,124 @@
+package eppobot
+
+import (
+	"../catalog"
+	"../shortlink"
+	"fmt"
+	"log"
+	"strings"
+)
+
+//Set up the shortener that the config asks for. Without one, links stay as
+//long as they are.
+func (b *QuoteBot) LoadShortener() {
+	shortener, store, err := shortlink.New(b.Shortlinks)
+	if err != nil {
+		log.Println("Links won't be shortened:", err)
+		return
+	}
+	b.shortener, b.Links = shortener, store
+}
+
+//Links are logged and get a preview, and a short version if they're long
+func handleLinks(b *QuoteBot, in *IrcMessage, query []string) {
+	links := findLinks(in.Text)
+	b.logLinks(in, links)
+	go b.previewLinks(in, links)
+	shortenLink(b, in, query)
+}
+
+//Only in channels, and in the background, as a remote shortener can take a
+//while to answer
+func shortenLink(b *QuoteBot, in *IrcMessage, query []string) {
+	if b.shortener == nil || !strings.HasPrefix(in.Channel, "#") {
+		return
+	}
+	//Only the first long one, so it doesn't get too busy
+	for _, link := range findLinks(in.Text) {
+		if len(link) <= b.UrlLength {
+			continue
+		}
+		go func(link string) {
+			short, err := b.shortener.Shorten(link, in.Sender)
+			if err != nil {
+				log.Println("Can't shorten", link+":", err)
+				return
+			}
+			b.Output <- &IrcMessage{
+				Channel: in.Channel,
+				Text:    short,
+			}
+		}(link)
+		return
+	}
+}
+
+//"!short link" shortens it, however long it is
+func shortCommand(b *QuoteBot, in *IrcMessage, args *Args) {
+	say := func(key string, params catalog.Params) {
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    b.text(in.Channel, key, params),
+		}
+	}
+	if b.shortener == nil {
+		say("short.off", nil)
+		return
+	}
+	//Not in private, where nobody sees what gets shortened
+	if !strings.HasPrefix(in.Channel, "#") {
+		say("short.private", nil)
+		return
+	}
+	go func() {
+		short, err := b.shortener.Shorten(args.Get("link"), in.Sender)
+		switch {
+		case err == shortlink.ErrNotALink:
+			say("short.invalid", nil)
+		case err == shortlink.ErrTooMany:
+			say("short.toomany", nil)
+		case err == shortlink.ErrFull:
+			say("short.full", nil)
+		case err != nil:
+			log.Println("Can't shorten", args.Get("link")+":", err)
+			say("short.failed", catalog.Params{"error": err.Error()})
+		default:
+			b.Output <- &IrcMessage{
+				Channel: in.Channel,
+				Text:    short,
+			}
+		}
+	}()
+}
+
+//"!shortstats link" tells how a short link of ours has been doing
+func shortStats(b *QuoteBot, in *IrcMessage, args *Args) {
+	say := func(key string, params catalog.Params) {
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    b.text(in.Channel, key, params),
+		}
+	}
+	if b.Links == nil {
+		say("short.nostats", nil)
+		return
+	}
+	code, link, ok := b.Links.Lookup(strings.TrimSpace(args.Get("link")))
+	if !ok {
+		say("short.unknown", nil)
+		return
+	}
+	params := catalog.Params{
+		"short":   b.Links.BaseUrl + "/" + code,
+		"url":     link.Url,
+		"clicks":  fmt.Sprint(link.Clicks),
+		"created": link.Created.Format("2006-01-02"),
+		"by":      link.By,
+	}
+	if link.Clicks == 0 {
+		say("short.stats.none", params)
+		return
+	}
+	params["last"] = link.LastClick.Format("2006-01-02 15:04")
+	say("short.stats", params)
+}
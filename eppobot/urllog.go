@@ -0,0 +1,297 @@
+package eppobot
+
+import (
+	"../catalog"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUrlLog    = "urls.json"
+	defaultUrlExport = "urls.csv"
+	//How many links we remember; the oldest are forgotten first
+	urlLogMax = 5000
+	//How many links !urls shows
+	urlsMaxResults = 5
+	//How long new links wait to be saved, with whatever comes in after them
+	urlLogSaveDelay = 30 * time.Second
+)
+
+//A link someone posted in a channel
+type loggedUrl struct {
+	Url     string
+	Channel string
+	Sender  string
+	Time    time.Time
+	Title   string `json:",omitempty"`
+	//The link as sameUrl has it, so reposts are found without parsing
+	//every link again
+	Same string `json:",omitempty"`
+}
+
+//Every link seen in a channel, oldest first. Titles are filled in by the
+//goroutines that fetch previews, so this has a lock of its own.
+type urlLog struct {
+	lock    sync.Mutex
+	file    string
+	entries []*loggedUrl
+	//Whether a save is on its way
+	saving bool
+}
+
+//Read the links seen before
+func (b *QuoteBot) LoadUrlLog() {
+	l := &b.urls
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.file = b.UrlLog
+	if l.file == "" {
+		l.file = defaultUrlLog
+	}
+	jsonBlob, err := ioutil.ReadFile(l.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Can't read the link log,", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(jsonBlob, &l.entries); err != nil {
+		log.Println("Can't parse the link log,", err)
+	}
+	for _, entry := range l.entries {
+		if entry.Same == "" {
+			entry.Same = sameUrl(entry.Url)
+		}
+	}
+}
+
+//Save a while from now, so a busy channel doesn't mean writing the whole log
+//for every link. Call with the lock held.
+func (l *urlLog) saveSoon() {
+	if l.saving || l.file == "" {
+		return
+	}
+	l.saving = true
+	time.AfterFunc(urlLogSaveDelay, l.flush)
+}
+
+//Save now
+func (l *urlLog) flush() {
+	l.lock.Lock()
+	l.saving = false
+	if l.file == "" {
+		l.lock.Unlock()
+		return
+	}
+	file := l.file
+	jsonBlob, err := json.Marshal(l.entries)
+	l.lock.Unlock()
+	if err == nil {
+		err = ioutil.WriteFile(file, jsonBlob, 0644)
+	}
+	if err != nil {
+		log.Println("Can't save the link log,", err)
+	}
+}
+
+//The same link, however it was written down: without the fragment, the
+//tracking parameters and a trailing slash
+func sameUrl(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	query := u.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "utm_") {
+			query.Del(key)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return strings.TrimPrefix(strings.TrimPrefix(u.String(), "https://"), "http://")
+}
+
+//Remember the links in a message, and say so if one of them was posted in
+//the channel before, by someone else
+func (b *QuoteBot) logLinks(in *IrcMessage, links []string) {
+	if !strings.HasPrefix(in.Channel, "#") || len(links) == 0 {
+		return
+	}
+	l := &b.urls
+	l.lock.Lock()
+	var earlier *loggedUrl
+	for _, link := range links {
+		same := sameUrl(link)
+		for _, entry := range l.entries {
+			if earlier == nil && entry.Same == same && strings.EqualFold(entry.Channel, in.Channel) &&
+				!strings.EqualFold(entry.Sender, in.Sender) {
+				earlier = entry
+				break
+			}
+		}
+		l.entries = append(l.entries, &loggedUrl{Url: link, Channel: in.Channel, Sender: in.Sender, Time: time.Now(), Same: same})
+	}
+	if len(l.entries) > urlLogMax {
+		l.entries = l.entries[len(l.entries)-urlLogMax:]
+	}
+	l.saveSoon()
+	var params catalog.Params
+	if earlier != nil {
+		params = catalog.Params{"sender": earlier.Sender, "date": earlier.Time.Format("2006-01-02 15:04")}
+	}
+	l.lock.Unlock()
+
+	if params != nil {
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    b.text(in.Channel, "urls.old", params),
+		}
+	}
+}
+
+//Note the title of a link, once we know it
+func (b *QuoteBot) logTitle(channel, link, title string) {
+	l := &b.urls
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if entry := l.entries[i]; entry.Url == link && entry.Channel == channel {
+			if entry.Title == title {
+				return
+			}
+			entry.Title = title
+			l.saveSoon()
+			return
+		}
+	}
+}
+
+//"!urls" shows the latest links in the channel, "!urls erik" those of erik,
+//"!urls koffie" those about koffie and "!urls erik koffie" both. Not in
+//private, or anyone could read along with channels they're not in.
+func listUrls(b *QuoteBot, in *IrcMessage, args *Args) {
+	if !strings.HasPrefix(in.Channel, "#") {
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    b.text(in.Channel, "urls.private", nil),
+		}
+		return
+	}
+	words := strings.Fields(args.Get("zoek"))
+	l := &b.urls
+	l.lock.Lock()
+	var inChannel []loggedUrl
+	senders := make(map[string]bool)
+	for _, entry := range l.entries {
+		if strings.EqualFold(entry.Channel, in.Channel) {
+			inChannel = append(inChannel, *entry)
+			senders[strings.ToLower(entry.Sender)] = true
+		}
+	}
+	l.lock.Unlock()
+
+	nick := ""
+	if len(words) > 0 && senders[strings.ToLower(words[0])] {
+		nick, words = words[0], words[1:]
+	}
+	search := strings.Join(words, " ")
+	var found []loggedUrl
+	for i := len(inChannel) - 1; i >= 0 && len(found) < urlsMaxResults; i-- {
+		entry := inChannel[i]
+		if nick != "" && !strings.EqualFold(entry.Sender, nick) {
+			continue
+		}
+		if search != "" && !CaseInsContains(entry.Url, search) && !CaseInsContains(entry.Title, search) {
+			continue
+		}
+		found = append(found, entry)
+	}
+
+	if len(found) == 0 {
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    b.text(in.Channel, "urls.none", nil),
+		}
+		return
+	}
+	for _, entry := range found {
+		key := "urls.line"
+		if entry.Title != "" {
+			key = "urls.line.title"
+		}
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text: b.text(in.Channel, key, catalog.Params{
+				"date":   entry.Time.Format("2006-01-02 15:04"),
+				"sender": entry.Sender,
+				"title":  entry.Title,
+				"url":    entry.Url,
+			}),
+		}
+	}
+}
+
+//"!urlexport" writes the whole link log to a CSV file. It overwrites a file
+//on the bot's host, so like !raw only in private, and only for Admins.
+func exportUrls(b *QuoteBot, in *IrcMessage, args *Args) {
+	if in.Channel != in.Sender || !anyMask(b.Admins, in, "Admin") {
+		return
+	}
+	file := b.UrlExport
+	if file == "" {
+		file = defaultUrlExport
+	}
+	//A copy, as titles may still be filled in while the file is written
+	l := &b.urls
+	l.lock.Lock()
+	entries := make([]loggedUrl, len(l.entries))
+	for i, entry := range l.entries {
+		entries[i] = *entry
+	}
+	l.lock.Unlock()
+	go func() {
+		err := writeUrls(file, entries)
+		if err != nil {
+			log.Println("Can't export the link log,", err)
+			b.Output <- &IrcMessage{
+				Channel: in.Channel,
+				Text:    b.text(in.Channel, "urls.export.failed", catalog.Params{"error": err.Error()}),
+			}
+			return
+		}
+		b.Output <- &IrcMessage{
+			Channel: in.Channel,
+			Text:    b.text(in.Channel, "urls.exported", catalog.Params{"count": fmt.Sprint(len(entries)), "file": file}),
+		}
+	}()
+}
+
+func writeUrls(file string, entries []loggedUrl) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(out)
+	w.Write([]string{"time", "channel", "sender", "url", "title"})
+	for _, entry := range entries {
+		w.Write([]string{entry.Time.Format(time.RFC3339), entry.Channel, entry.Sender, entry.Url, entry.Title})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,434 @@
+package feedbot
+
+import (
+	"../catalog"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//The feedbot polls RSS and Atom feeds, each in its own goroutine, and relays
+//new entries. Requests are handled by ListenControl. Everything they share is
+//guarded by lock.
+type FeedBot struct {
+	Output  chan Message
+	Control chan Request
+	Config  *Config
+	State   map[string]*FeedState
+	//Messages, in the language of the channel we talk to
+	Texts    *catalog.Catalog
+	Language string
+
+	lock  sync.Mutex
+	stops map[string]chan bool
+	//Counts the feeds added, so a poll can tell its feed from a new one
+	//under the same name
+	added int
+}
+
+type Config struct {
+	Feeds []*Feed
+}
+
+type Feed struct {
+	Name string
+	Url  string
+	//Minutes between polls
+	Interval int
+	//Where to relay to. Empty means the main channel.
+	Channels []string
+	//Which time the feed was added since we started
+	generation int
+}
+
+//What we remember of a feed between polls, and between restarts
+type FeedState struct {
+	ETag         string
+	LastModified string
+	//Ids of the entries that were on the feed last time. Anything that
+	//drops off the feed is forgotten, so this doesn't grow forever.
+	Seen []string
+}
+
+//Something to say. An empty Channel means the main channel.
+type Message struct {
+	Channel string
+	Text    string
+}
+
+type Command string
+
+const (
+	CTL_LIST_FEEDS Command = "list"
+	CTL_ADD_FEED   Command = "add"
+	CTL_DEL_FEED   Command = "del"
+)
+
+//A Request asks the feedbot to do something, like its twitterbot counterpart.
+//Only Feed.Name matters when deleting.
+type Request struct {
+	Command Command
+	Feed    Feed
+	Reply   chan<- string
+}
+
+func (r Request) reply(text string) {
+	if r.Reply != nil {
+		r.Reply <- text
+	}
+}
+
+const (
+	configFile = "feeds.json"
+	stateFile  = "feedstate.json"
+	//Minutes between polls when the feed doesn't say
+	defaultInterval = 15
+	//How long we wait for a feed, and how much of it we read
+	fetchTimeout  = 30 * time.Second
+	fetchMaxBytes = 4 << 20
+)
+
+//Feeds that never answer must not hold up the requests, nor the polls
+var httpClient = &http.Client{Timeout: fetchTimeout}
+
+func CreateBot(OutputChannel chan Message, ControlChannel chan Request, texts *catalog.Catalog, language string) *FeedBot {
+	b := newBot(OutputChannel, ControlChannel, texts, language)
+	if !b.ReadConfig() {
+		return nil
+	}
+	b.ReadState()
+	go b.ListenControl()
+	b.lock.Lock()
+	for _, feed := range b.Config.Feeds {
+		go b.poll(*feed)
+		b.startPolling(*feed)
+	}
+	b.lock.Unlock()
+	return b
+}
+
+func newBot(OutputChannel chan Message, ControlChannel chan Request, texts *catalog.Catalog, language string) *FeedBot {
+	return &FeedBot{
+		Output:   OutputChannel,
+		Control:  ControlChannel,
+		Config:   new(Config),
+		State:    make(map[string]*FeedState),
+		Texts:    texts,
+		Language: language,
+		stops:    make(map[string]chan bool),
+	}
+}
+
+func (f *Feed) interval() time.Duration {
+	if f.Interval <= 0 {
+		return defaultInterval * time.Minute
+	}
+	return time.Duration(f.Interval) * time.Minute
+}
+
+//Start a goroutine polling the feed every so often. Call with the lock held.
+func (b *FeedBot) startPolling(feed Feed) {
+	stop := make(chan bool)
+	b.stops[strings.ToLower(feed.Name)] = stop
+	go func() {
+		ticker := time.NewTicker(feed.interval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				b.poll(feed)
+			}
+		}
+	}()
+}
+
+func (b *FeedBot) poll(feed Feed) {
+	if err := b.Poll(feed); err != nil {
+		log.Printf("fb: Can't poll %s: %s\n", feed.Name, err)
+	}
+}
+
+func (b *FeedBot) ListenControl() {
+	for r := range b.Control {
+		b.HandleRequest(r)
+		if r.Reply != nil {
+			close(r.Reply)
+		}
+	}
+}
+
+func (b *FeedBot) HandleRequest(r Request) {
+	switch r.Command {
+	case CTL_LIST_FEEDS:
+		b.ListFeeds(r)
+	case CTL_ADD_FEED:
+		b.AddFeed(r)
+	case CTL_DEL_FEED:
+		b.DelFeed(r)
+	default:
+		log.Printf("fb: Ignoring invalid control <%s>\n", r.Command)
+	}
+}
+
+func (b *FeedBot) text(key string, params catalog.Params) string {
+	return b.Texts.Text(b.Language, key, params)
+}
+
+//Look up a feed by name. Call with the lock held.
+func (b *FeedBot) find(name string) (int, *Feed) {
+	for i, feed := range b.Config.Feeds {
+		if strings.EqualFold(feed.Name, name) {
+			return i, feed
+		}
+	}
+	return -1, nil
+}
+
+//Fetch the feed, unless it hasn't changed since last time, in which case
+//entries is nil. Returns the new state of the feed along with the entries.
+func (b *FeedBot) fetch(feed Feed, old FeedState) ([]Entry, *FeedState, error) {
+	req, err := http.NewRequest("GET", feed.Url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if old.ETag != "" {
+		req.Header.Set("If-None-Match", old.ETag)
+	}
+	if old.LastModified != "" {
+		req.Header.Set("If-Modified-Since", old.LastModified)
+	}
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotModified {
+		return nil, &old, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s", response.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, fetchMaxBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := parseFeed(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state := &FeedState{
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+	}
+	for _, entry := range entries {
+		state.Seen = append(state.Seen, entry.Id)
+	}
+	return entries, state, nil
+}
+
+//Check the feed for new entries and relay them
+func (b *FeedBot) Poll(feed Feed) error {
+	b.lock.Lock()
+	var old FeedState
+	state, known := b.State[strings.ToLower(feed.Name)]
+	if known {
+		old = *state
+	}
+	b.lock.Unlock()
+
+	entries, state, err := b.fetch(feed, old)
+	if err != nil || entries == nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range old.Seen {
+		seen[id] = true
+	}
+	b.lock.Lock()
+	if _, current := b.find(feed.Name); current == nil || current.Url != feed.Url || current.generation != feed.generation {
+		//Deleted, or replaced, while we were fetching
+		b.lock.Unlock()
+		return nil
+	}
+	b.State[strings.ToLower(feed.Name)] = state
+	b.lock.Unlock()
+	b.SaveState()
+
+	if !known {
+		return nil
+	}
+	//Feeds put the newest entry first; tell them in the order they happened
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !seen[entries[i].Id] {
+			b.relay(feed, entries[i])
+		}
+	}
+	return nil
+}
+
+func (b *FeedBot) relay(feed Feed, entry Entry) {
+	text := fmt.Sprintf("[%s] %s %s", cleanText(feed.Name), entry.Title, entry.Link)
+	text = strings.TrimSpace(text)
+	if len(feed.Channels) == 0 {
+		b.Output <- Message{Text: text}
+		return
+	}
+	for _, channel := range feed.Channels {
+		b.Output <- Message{Channel: channel, Text: text}
+	}
+}
+
+func (b *FeedBot) ListFeeds(r Request) {
+	b.lock.Lock()
+	feeds := make([]Feed, len(b.Config.Feeds))
+	for i, feed := range b.Config.Feeds {
+		feeds[i] = *feed
+	}
+	b.lock.Unlock()
+
+	if len(feeds) == 0 {
+		r.reply(b.text("feeds.none", nil))
+		return
+	}
+	for _, feed := range feeds {
+		channels := strings.Join(feed.Channels, ", ")
+		if channels == "" {
+			channels = b.text("feeds.main", nil)
+		}
+		r.reply(b.text("feeds.entry", catalog.Params{
+			"name":     feed.Name,
+			"url":      feed.Url,
+			"interval": int(feed.interval() / time.Minute),
+			"channels": channels,
+		}))
+	}
+}
+
+//Add a feed, after checking that there is a feed at the other end. Whatever
+//is on it already counts as seen, so we don't flood the channel.
+func (b *FeedBot) AddFeed(r Request) {
+	feed := r.Feed
+	b.lock.Lock()
+	_, existing := b.find(feed.Name)
+	b.lock.Unlock()
+	if existing != nil {
+		r.reply(b.text("feeds.exists", catalog.Params{"name": existing.Name}))
+		return
+	}
+	if u, err := url.Parse(feed.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		r.reply(b.text("feeds.badurl", nil))
+		return
+	}
+
+	_, state, err := b.fetch(feed, FeedState{})
+	if err != nil {
+		r.reply(b.text("feeds.failed", catalog.Params{"error": err.Error()}))
+		return
+	}
+
+	b.lock.Lock()
+	b.added++
+	feed.generation = b.added
+	b.Config.Feeds = append(b.Config.Feeds, &feed)
+	b.State[strings.ToLower(feed.Name)] = state
+	b.startPolling(feed)
+	b.lock.Unlock()
+	b.SaveConfig()
+	b.SaveState()
+	r.reply(b.text("feeds.added", catalog.Params{"name": feed.Name}))
+}
+
+func (b *FeedBot) DelFeed(r Request) {
+	b.lock.Lock()
+	i, feed := b.find(r.Feed.Name)
+	if feed == nil {
+		b.lock.Unlock()
+		r.reply(b.text("feeds.unknown", nil))
+		return
+	}
+	b.Config.Feeds = append(b.Config.Feeds[:i], b.Config.Feeds[i+1:]...)
+	key := strings.ToLower(feed.Name)
+	delete(b.State, key)
+	if stop, ok := b.stops[key]; ok {
+		close(stop)
+		delete(b.stops, key)
+	}
+	b.lock.Unlock()
+	b.SaveConfig()
+	b.SaveState()
+	r.reply(b.text("feeds.removed", catalog.Params{"name": feed.Name}))
+}
+
+//Read the feeds to poll. Without a file, there are none yet.
+func (b *FeedBot) ReadConfig() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	jsonBlob, ioErr := ioutil.ReadFile(configFile)
+	if os.IsNotExist(ioErr) {
+		return true
+	}
+	if ioErr != nil {
+		log.Printf("Error opening file %s: %s\n", configFile, ioErr)
+		return false
+	}
+	if jsonErr := json.Unmarshal(jsonBlob, b.Config); jsonErr != nil {
+		log.Printf("Error parsing file %s: %s\n", configFile, jsonErr)
+		return false
+	}
+	return true
+}
+
+func (b *FeedBot) SaveConfig() bool {
+	b.lock.Lock()
+	jsonBlob, jsonErr := json.MarshalIndent(b.Config, "", "\t")
+	b.lock.Unlock()
+	if jsonErr != nil {
+		log.Println("Error converting to JSON:", jsonErr)
+		return false
+	}
+	return ioutil.WriteFile(configFile, jsonBlob, 0644) == nil
+}
+
+//Read what we have seen before. Feeds we know nothing about are fetched as if
+//they were just added.
+func (b *FeedBot) ReadState() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	jsonBlob, ioErr := ioutil.ReadFile(stateFile)
+	if ioErr != nil {
+		if !os.IsNotExist(ioErr) {
+			log.Printf("Error opening file %s: %s\n", stateFile, ioErr)
+		}
+		return
+	}
+	if jsonErr := json.Unmarshal(jsonBlob, &b.State); jsonErr != nil {
+		log.Printf("Error parsing file %s: %s\n", stateFile, jsonErr)
+	}
+}
+
+func (b *FeedBot) SaveState() bool {
+	b.lock.Lock()
+	jsonBlob, jsonErr := json.Marshal(b.State)
+	b.lock.Unlock()
+	if jsonErr != nil {
+		log.Println("Error converting to JSON:", jsonErr)
+		return false
+	}
+	if err := ioutil.WriteFile(stateFile, jsonBlob, 0644); err != nil {
+		log.Printf("Error writing file %s: %s\n", stateFile, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,255 @@
+package feedbot
+
+import (
+	"../catalog"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A blog whose entries the test can change. It knows its ETag, and counts
+// how often it was spared sending the whole feed.
+type fakeBlog struct {
+	lock        sync.Mutex
+	entries     []string
+	notModified int
+}
+
+func (f *fakeBlog) post(title string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.entries = append([]string{title}, f.entries...)
+}
+
+func (f *fakeBlog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	etag := fmt.Sprintf(`"%d"`, len(f.entries))
+	if r.Header.Get("If-None-Match") == etag {
+		f.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	switch r.URL.Path {
+	case "/atom":
+		fmt.Fprint(w, `<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom"><title>Blog</title>`)
+		for _, title := range f.entries {
+			fmt.Fprintf(w, `<entry><id>tag:blog,%s</id><title>%s</title>`+
+				`<link rel="edit" href="/edit"/><link href="http://blog/%s"/></entry>`, title, title, title)
+		}
+		fmt.Fprint(w, `</feed>`)
+	case "/rss":
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Blog</title>`)
+		for _, title := range f.entries {
+			fmt.Fprintf(w, "<item><title>%s</title><link>http://blog/%s</link><guid>%s</guid></item>", title, title, title)
+		}
+		fmt.Fprint(w, `</channel></rss>`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Run the test in a directory of its own, as the bot keeps its files in the
+// working directory
+func inTempDir(test *testing.T) func() {
+	dir, err := ioutil.TempDir("", "feedbot")
+	if err != nil {
+		test.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func initFakeBot() *FeedBot {
+	return newBot(make(chan Message, 10), make(chan Request), catalog.New(), catalog.DefaultLanguage)
+}
+
+// Ask for something and collect the answer
+func (b *FeedBot) ask(command Command, feed Feed) []string {
+	reply := make(chan string)
+	go func() {
+		b.HandleRequest(Request{Command: command, Feed: feed, Reply: reply})
+		close(reply)
+	}()
+	var lines []string
+	for line := range reply {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Everything the bot said so far
+func (b *FeedBot) said() []Message {
+	var messages []Message
+	for {
+		select {
+		case m := <-b.Output:
+			messages = append(messages, m)
+		default:
+			return messages
+		}
+	}
+}
+
+func TestPoll(test *testing.T) {
+	defer inTempDir(test)()
+	for _, path := range []string{"/rss", "/atom"} {
+		blog := &fakeBlog{entries: []string{"Oud"}}
+		server := httptest.NewServer(blog)
+		defer server.Close()
+		b := initFakeBot()
+		feed := Feed{Name: "blog" + path[1:], Url: server.URL + path, Channels: []string{"#a", "#b"}}
+		b.Config.Feeds = []*Feed{&feed}
+
+		// The first time, everything is old news
+		if err := b.Poll(feed); err != nil {
+			test.Fatal("Failed first poll with", err)
+		}
+		if said := b.said(); len(said) != 0 {
+			test.Error("Relayed old entries", said)
+		}
+		blog.post("Nieuw")
+		blog.post("Nieuwer")
+		b.Poll(feed)
+		said := b.said()
+		want := []Message{
+			{"#a", "[" + feed.Name + "] Nieuw http://blog/Nieuw"},
+			{"#b", "[" + feed.Name + "] Nieuw http://blog/Nieuw"},
+			{"#a", "[" + feed.Name + "] Nieuwer http://blog/Nieuwer"},
+			{"#b", "[" + feed.Name + "] Nieuwer http://blog/Nieuwer"},
+		}
+		if fmt.Sprint(said) != fmt.Sprint(want) {
+			test.Error("Failed poll with", said)
+		}
+
+		// Nothing changed, and the server can tell
+		before := blog.notModified
+		b.Poll(feed)
+		if said := b.said(); len(said) != 0 || blog.notModified != before+1 {
+			test.Error("Failed conditional poll with", said, blog.notModified)
+		}
+
+		// After a restart, we still know what we've seen
+		blog.post("Nog " + path[1:])
+		b = initFakeBot()
+		b.Config.Feeds = []*Feed{&feed}
+		b.ReadState()
+		b.Poll(feed)
+		if said := b.said(); len(said) != 2 || !strings.Contains(said[0].Text, "Nog") {
+			test.Error("Failed poll after restart with", said)
+		}
+	}
+}
+
+func TestRelayOneLine(test *testing.T) {
+	entries, err := parseFeed([]byte(`<?xml version="1.0"?><rss version="2.0"><channel>` +
+		`<item><title>Nieuw&#13;&#10;bericht</title><link>http://blog/x&#13;&#10;QUIT :weg</link></item></channel></rss>`))
+	if err != nil || len(entries) != 1 {
+		test.Fatal("Failed parse with", entries, err)
+	}
+	b := initFakeBot()
+	b.relay(Feed{Name: "Blog\r\nJOIN #elders", Channels: []string{"#bottest"}}, entries[0])
+	if said := b.said(); len(said) != 1 || said[0].Text != "[Blog JOIN #elders] Nieuw bericht http://blog/xQUIT:weg" {
+		test.Errorf("Relayed %q", said)
+	}
+}
+
+func TestStalePoll(test *testing.T) {
+	defer inTempDir(test)()
+	blog := &fakeBlog{entries: []string{"Oud"}}
+	server := httptest.NewServer(blog)
+	defer server.Close()
+
+	// The feed was deleted and added again while the poll was fetching
+	b := initFakeBot()
+	feed := Feed{Name: "blog", Url: server.URL + "/rss"}
+	again := feed
+	again.generation = 1
+	b.Config.Feeds = []*Feed{&again}
+	b.State["blog"] = &FeedState{ETag: "nieuw"}
+	if err := b.Poll(feed); err != nil {
+		test.Fatal("Failed poll with", err)
+	}
+	if b.State["blog"].ETag != "nieuw" {
+		test.Error("An old poll overwrote the state of the new feed")
+	}
+}
+
+func TestFetchTimeout(test *testing.T) {
+	defer inTempDir(test)()
+	hang := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer server.Close()
+	defer close(hang)
+	defer func(client *http.Client) { httpClient = client }(httpClient)
+	httpClient = &http.Client{Timeout: 100 * time.Millisecond}
+
+	b := initFakeBot()
+	lines := b.ask(CTL_ADD_FEED, Feed{Name: "traag", Url: server.URL + "/rss"})
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "Daar kan ik niets van maken") {
+		test.Error("Failed unanswered feed with", lines)
+	}
+}
+
+func TestCommands(test *testing.T) {
+	defer inTempDir(test)()
+	blog := &fakeBlog{entries: []string{"Oud"}}
+	server := httptest.NewServer(blog)
+	defer server.Close()
+	b := initFakeBot()
+	texts := catalog.New()
+
+	if lines := b.ask(CTL_LIST_FEEDS, Feed{}); len(lines) != 1 || lines[0] != texts.Text("nl", "feeds.none", nil) {
+		test.Error("Failed empty list with", lines)
+	}
+	lines := b.ask(CTL_ADD_FEED, Feed{Name: "Blog", Url: server.URL + "/rss", Interval: 60})
+	if len(lines) != 1 || lines[0] != texts.Text("nl", "feeds.added", catalog.Params{"name": "Blog"}) {
+		test.Error("Failed add with", lines)
+	}
+	if lines := b.ask(CTL_ADD_FEED, Feed{Name: "blog", Url: server.URL + "/atom"}); len(lines) != 1 ||
+		lines[0] != texts.Text("nl", "feeds.exists", catalog.Params{"name": "Blog"}) {
+		test.Error("Added a feed twice with", lines)
+	}
+	if lines := b.ask(CTL_ADD_FEED, Feed{Name: "Stuk", Url: server.URL + "/niets"}); len(lines) != 1 ||
+		!strings.HasPrefix(lines[0], texts.Text("nl", "feeds.failed", catalog.Params{"error": ""})) {
+		test.Error("Added a broken feed with", lines)
+	}
+	if lines := b.ask(CTL_ADD_FEED, Feed{Name: "Lokaal", Url: "/etc/passwd"}); len(lines) != 1 ||
+		lines[0] != texts.Text("nl", "feeds.badurl", nil) {
+		test.Error("Added a file with", lines)
+	}
+	lines = b.ask(CTL_LIST_FEEDS, Feed{})
+	if len(lines) != 1 || !strings.Contains(lines[0], server.URL+"/rss") || !strings.Contains(lines[0], "60") {
+		test.Error("Failed list with", lines)
+	}
+
+	// The new feed is saved
+	saved := initFakeBot()
+	if !saved.ReadConfig() || len(saved.Config.Feeds) != 1 || saved.Config.Feeds[0].Name != "Blog" {
+		test.Error("Failed to save feeds")
+	}
+
+	if lines := b.ask(CTL_DEL_FEED, Feed{Name: "BLOG"}); len(lines) != 1 ||
+		lines[0] != texts.Text("nl", "feeds.removed", catalog.Params{"name": "Blog"}) {
+		test.Error("Failed delete with", lines)
+	}
+	if lines := b.ask(CTL_DEL_FEED, Feed{Name: "Blog"}); len(lines) != 1 || lines[0] != texts.Text("nl", "feeds.unknown", nil) {
+		test.Error("Deleted twice with", lines)
+	}
+	if said := b.said(); len(said) != 0 {
+		test.Error("Relayed old entries", said)
+	}
+}
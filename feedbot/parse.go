@@ -0,0 +1,89 @@
+package feedbot
+
+import (
+	"encoding/xml"
+	"strings"
+	"unicode"
+)
+
+//An Entry is one post on a feed
+type Entry struct {
+	Id    string
+	Title string
+	Link  string
+}
+
+//Both RSS and Atom fit in here: RSS 2.0 has its items in a channel, RSS 1.0
+//has them next to it and Atom calls them entries.
+type feedDocument struct {
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+	Guid  string `xml:"guid"`
+	About string `xml:"about,attr"`
+}
+
+type atomEntry struct {
+	Title string `xml:"title"`
+	Id    string `xml:"id"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+}
+
+//Get the entries from an RSS or Atom feed, newest first, as feeds have them
+func parseFeed(data []byte) ([]Entry, error) {
+	var doc feedDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, item := range append(doc.Channel.Items, doc.Items...) {
+		entry := Entry{Id: item.Guid, Title: item.Title, Link: strings.TrimSpace(item.Link)}
+		if entry.Id == "" {
+			entry.Id = item.About
+		}
+		entries = append(entries, entry)
+	}
+	for _, item := range doc.Entries {
+		entry := Entry{Id: item.Id, Title: item.Title}
+		for _, link := range item.Links {
+			if link.Rel == "" || link.Rel == "alternate" {
+				entry.Link = link.Href
+				break
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	for i := range entries {
+		entries[i].Id = strings.TrimSpace(entries[i].Id)
+		if entries[i].Id == "" {
+			entries[i].Id = entries[i].Link
+		}
+		entries[i].Title = cleanText(entries[i].Title)
+		entries[i].Link = strings.Join(strings.Fields(cleanText(entries[i].Link)), "")
+	}
+	return entries, nil
+}
+
+//One line of text without control characters, which IRC would take for
+//commands of its own, CTCP or colours
+func cleanText(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r < 0x20 && !unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, text)
+	return strings.Join(strings.Fields(text), " ")
+}
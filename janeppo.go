@@ -2,11 +2,13 @@ package main
 
 import (
 	je "./eppobot"
+	"./feedbot"
 	"./twitterbot"
 	"flag"
 	"fmt"
 	"log"
 	"math/rand"
+	"net/http"
 	"time"
 )
 
@@ -28,17 +30,35 @@ func main() {
 	eppo := je.CreateBot(conf, reader, ircSend, quotes)
 	defer conn.Close()
 
-	eppo.TwitterCtl = make(chan string)
+	//Prepare the Twitterbot and the Feedbot. Without them, their control
+	//channels stay nil, so the bot can tell they're off.
+	twitterSend := make(chan twitterbot.Message)
+	twitterCtl := make(chan twitterbot.Request)
+	if tb := twitterbot.CreateBot(twitterSend, twitterCtl, eppo.Texts, eppo.Lang(conf.Channel), eppo.Prefix(conf.Channel)); tb != nil {
+		eppo.TwitterCtl = twitterCtl
+		go tb.ReadContinuous()
+	} else {
+		log.Println("Twitterbot not started, check twitter.json")
+	}
+	feedSend := make(chan feedbot.Message)
+	feedCtl := make(chan feedbot.Request)
+	if feedbot.CreateBot(feedSend, feedCtl, eppo.Texts, eppo.Lang(conf.Channel)) != nil {
+		eppo.FeedCtl = feedCtl
+	} else {
+		log.Println("Feedbot not started, check feeds.json")
+	}
+
 	go eppo.ChatContinuous()
+	go eppo.WatchP2000()
 
 	rand.Seed(time.Now().Unix())
 
-	//Prepare the Twitterbot
-	twitterSend := make(chan string)
-	go func() {
-		tb := twitterbot.CreateBot(twitterSend, eppo.TwitterCtl)
-		tb.ReadContinuous()
-	}()
+	//Serve our own short links
+	if eppo.Links != nil && conf.Shortlinks.Listen != "" {
+		go func() {
+			log.Println("Short links stopped:", http.ListenAndServe(conf.Shortlinks.Listen, eppo.Links))
+		}()
+	}
 
 	send := func(line je.IrcOperation) {
 		fmt.Fprint(conn, line.String())
@@ -52,12 +72,30 @@ func main() {
 		select {
 		case outLine := <-ircSend:
 			send(outLine)
-		case outLine := <-twitterSend:
+		case tweet := <-twitterSend:
+			channel := tweet.Channel
+			if channel == "" {
+				channel = conf.Channel
+			}
+			outLine := tweet.Text
+			if conf.Colors {
+				outLine = "\x0314" + outLine + "\x0f"
+			}
+			send(&je.IrcMessage{
+				Channel: channel,
+				Text:    outLine,
+			})
+		case feedLine := <-feedSend:
+			channel := feedLine.Channel
+			if channel == "" {
+				channel = conf.Channel
+			}
+			outLine := feedLine.Text
 			if conf.Colors {
 				outLine = "\x0314" + outLine + "\x0f"
 			}
 			send(&je.IrcMessage{
-				Channel: conf.Channel,
+				Channel: channel,
 				Text:    outLine,
 			})
 		}
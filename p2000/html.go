@@ -0,0 +1,149 @@
+package p2000
+
+import (
+	"bytes"
+	"code.google.com/p/go.net/html"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//Date formats the sites use, in Dutch time
+var timeLayouts = []string{"02-01-06 15:04:05", "02-01-2006 15:04:05", "2006-01-02 15:04:05"}
+
+//Dutch time, if this system knows it; the sites and alerts go by it
+var DutchTime = func() *time.Location {
+	location, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		return time.Local
+	}
+	return location
+}()
+
+//A P2000 site in HTML
+type Page struct {
+	Url    string
+	Region string
+	//Nil for one that gives up after a few seconds
+	Client *http.Client
+}
+
+func (p *Page) Messages() ([]Message, error) {
+	body, err := fetch(p.Client, p.Url)
+	if err != nil {
+		return nil, err
+	}
+	return ParseHTML(body, p.Region)
+}
+
+//Read the messages on a P2000 page. Sites now put them in a table, a row per
+//message with the time (class DT), the service (Di), the region (Re) and the
+//message (Md), followed by a row per pager that was called (Oms). Older ones
+//put each message in a <p class="bericht">, and nothing else. Region is for
+//messages that don't say where they're from.
+func ParseHTML(page []byte, region string) ([]Message, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+	messages := tableMessages(doc, region)
+	if len(messages) == 0 {
+		messages = paragraphMessages(doc, region)
+	}
+	if len(messages) == 0 {
+		return nil, ErrNoMessages
+	}
+	return messages, nil
+}
+
+func tableMessages(doc *html.Node, region string) []Message {
+	var messages []Message
+	for _, row := range findAll(doc, func(n *html.Node) bool { return n.Data == "tr" }) {
+		cells := make(map[string]string)
+		for _, cell := range findAll(row, func(n *html.Node) bool { return n.Data == "td" }) {
+			for _, class := range []string{"DT", "Di", "Re", "Md", "Oms"} {
+				if hasClass(cell, class) {
+					cells[class] = text(cell)
+				}
+			}
+		}
+		if message, ok := cells["Md"]; ok {
+			if message == "" {
+				//Messages that didn't come through
+				continue
+			}
+			m := Message{Time: parseTime(cells["DT"]), Region: cells["Re"], Text: message}
+			messages = append(messages, complete(m, cells["Di"], region))
+			continue
+		}
+		if pager, ok := cells["Oms"]; ok && len(messages) > 0 {
+			last := &messages[len(messages)-1]
+			last.Capcodes = append(last.Capcodes, capcode.FindAllString(pager, -1)...)
+		}
+	}
+	return messages
+}
+
+func paragraphMessages(doc *html.Node, region string) []Message {
+	var messages []Message
+	for _, p := range findAll(doc, func(n *html.Node) bool { return n.Data == "p" && hasClass(n, "bericht") }) {
+		if message := text(p); message != "" {
+			m := complete(Message{Text: message}, "", region)
+			m.Capcodes = capcode.FindAllString(m.Text, -1)
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+//The elements under n that match, in the order of the page
+func findAll(n *html.Node, match func(*html.Node) bool) []*html.Node {
+	var found []*html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && match(child) {
+			found = append(found, child)
+		}
+		found = append(found, findAll(child, match)...)
+	}
+	return found
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key != "class" {
+			continue
+		}
+		for _, c := range strings.Fields(attr.Val) {
+			if c == class {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//All the text in n, on one line
+func text(n *html.Node) string {
+	var words []string
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			words = append(words, strings.Fields(n.Data)...)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+	collect(n)
+	return strings.Join(words, " ")
+}
+
+//The zero time if it isn't one
+func parseTime(s string) time.Time {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, DutchTime); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
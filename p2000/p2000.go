@@ -0,0 +1,240 @@
+//Package p2000 reads P2000 messages, the pager alerts of the Dutch emergency
+//services, from the sites that publish them. A Page reads a site in HTML, a
+//Feed reads one in RSS, and Sources tries one after the other.
+package p2000
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	//The page the bot always read, before it could be configured
+	DefaultUrl = "http://www.p2000zhz-rr.nl/p2000-brandweer-groningen.html"
+	//How long we wait for a site, and how much of it we read
+	fetchTimeout  = 10 * time.Second
+	fetchMaxBytes = 2 << 20
+)
+
+//A site that answered, but without anything that looks like a message. Most
+//likely it changed its layout.
+var ErrNoMessages = errors.New("no messages found")
+
+//One alert. Service is "brandweer", "ambulance" or "politie" if we can tell,
+//and Priority runs from 1 (most urgent) down; 0 if we can't tell.
+type Message struct {
+	Time     time.Time
+	Service  string
+	Priority int
+	Region   string
+	Text     string
+	//The pagers that were called, if the site lists them
+	Capcodes []string
+}
+
+//Something that stays the same when the message is read again
+func (m Message) Id() string {
+	if m.Time.IsZero() {
+		return m.Text
+	}
+	return m.Time.Format(time.RFC3339) + " " + m.Text
+}
+
+type Source interface {
+	//The latest messages, newest first
+	Messages() ([]Message, error)
+}
+
+//Where to read P2000 messages, as in config.json
+type Config struct {
+	//"html" (the default) or "rss"
+	Format string
+	Url    string
+	//For sites that don't say where a message is from
+	Region string
+}
+
+//Make the source the configs ask for; the first is tried first. Without any,
+//it reads the page the bot always read.
+func New(confs []Config) (Source, error) {
+	if len(confs) == 0 {
+		return &Page{Url: DefaultUrl, Region: "Groningen"}, nil
+	}
+	var sources Sources
+	for _, conf := range confs {
+		if conf.Url == "" {
+			return nil, errors.New("a P2000 source without a Url")
+		}
+		switch conf.Format {
+		case "", "html":
+			sources = append(sources, &Page{Url: conf.Url, Region: conf.Region})
+		case "rss":
+			sources = append(sources, &Feed{Url: conf.Url, Region: conf.Region})
+		default:
+			return nil, fmt.Errorf("unknown P2000 format %q", conf.Format)
+		}
+	}
+	if len(sources) == 1 {
+		return sources[0], nil
+	}
+	return sources, nil
+}
+
+//Sources are tried in turn, until one of them answers with messages
+type Sources []Source
+
+func (s Sources) Messages() ([]Message, error) {
+	err := errors.New("no P2000 sources")
+	for _, source := range s {
+		var messages []Message
+		messages, err = source.Messages()
+		if err == nil {
+			return messages, nil
+		}
+	}
+	return nil, err
+}
+
+//Get a site, or tell why not
+func fetch(client *http.Client, url string) ([]byte, error) {
+	if client == nil {
+		client = &http.Client{Timeout: fetchTimeout}
+	}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s said %s", url, response.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(response.Body, fetchMaxBytes))
+}
+
+var (
+	//"P 1", "P1", "PRIO 1", anywhere in the text
+	priorityCode = regexp.MustCompile(`(?i)\b(?:p|prio)\s?([1-5])\b`)
+	//Ambulances are called out with A1, A2, B1 or B2 at the start
+	ambulanceCode = regexp.MustCompile(`^(?i)(A1|A2|B1|B2)\b`)
+	//Pagers have seven digits
+	capcode = regexp.MustCompile(`\b\d{7}\b`)
+)
+
+//How urgent a message is, by the code in its text
+func priority(text string) int {
+	if code := ambulanceCode.FindStringSubmatch(text); code != nil {
+		switch strings.ToUpper(code[1]) {
+		case "A1":
+			return 1
+		case "A2":
+			return 2
+		default:
+			return 3
+		}
+	}
+	if code := priorityCode.FindStringSubmatch(text); code != nil {
+		return int(code[1][0] - '0')
+	}
+	return 0
+}
+
+//Which service a message is for, by what the site calls it or else by its
+//text. Empty if it's neither of the three.
+func service(name, text string) string {
+	if s := serviceIn(name); s != "" {
+		return s
+	}
+	if ambulanceCode.MatchString(text) {
+		return "ambulance"
+	}
+	return serviceIn(text)
+}
+
+func serviceIn(text string) string {
+	text = strings.ToLower(text)
+	switch {
+	case strings.Contains(text, "brandweer") || strings.Contains(text, "brw"):
+		return "brandweer"
+	case strings.Contains(text, "ambu") || strings.Contains(text, "traumaheli") || strings.Contains(text, "lifeliner"):
+		return "ambulance"
+	case strings.Contains(text, "politie"):
+		return "politie"
+	}
+	return ""
+}
+
+//Fill in what the site left for us to work out
+func complete(m Message, serviceName, region string) Message {
+	m.Text = strings.Join(strings.Fields(m.Text), " ")
+	m.Service = service(serviceName, m.Text)
+	m.Priority = priority(m.Text)
+	if m.Region == "" {
+		m.Region = region
+	}
+	return m
+}
+
+//Which messages to show: only those of the Services (any, if empty), no less
+//urgent than MaxPriority (any, if 0), and with all the Words in their region
+//or text
+type Filter struct {
+	Services    []string
+	MaxPriority int
+	Words       []string
+}
+
+var services = map[string]string{
+	"brandweer": "brandweer",
+	"brw":       "brandweer",
+	"ambulance": "ambulance",
+	"ambu":      "ambulance",
+	"politie":   "politie",
+	"pol":       "politie",
+}
+
+//Read a filter as people type it: "brandweer p1 groningen" is the most
+//urgent calls for the fire brigade in Groningen
+func ParseFilter(text string) Filter {
+	var f Filter
+	for _, word := range strings.Fields(text) {
+		lower := strings.ToLower(word)
+		if s, ok := services[lower]; ok {
+			f.Services = append(f.Services, s)
+			continue
+		}
+		if code := priorityCode.FindStringSubmatch(lower); code != nil && code[0] == lower {
+			f.MaxPriority = int(code[1][0] - '0')
+			continue
+		}
+		f.Words = append(f.Words, lower)
+	}
+	return f
+}
+
+func (f Filter) Match(m Message) bool {
+	if len(f.Services) > 0 {
+		found := false
+		for _, s := range f.Services {
+			found = found || s == m.Service
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.MaxPriority > 0 && (m.Priority == 0 || m.Priority > f.MaxPriority) {
+		return false
+	}
+	haystack := strings.ToLower(m.Region + " " + m.Text)
+	for _, word := range f.Words {
+		if !strings.Contains(haystack, word) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package p2000
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fixture(test *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		test.Fatal(err)
+	}
+	return data
+}
+
+func TestParseHTML(test *testing.T) {
+	messages, err := ParseHTML(fixture(test, "current.html"), "Nergens")
+	if err != nil || len(messages) != 6 {
+		test.Fatal("Failed current page with", len(messages), err)
+	}
+	first := messages[0]
+	if first.Text != "P 1 BDH-01 Middelbrand (Wonen) Grote Markt Groningen 012331" ||
+		first.Service != "brandweer" || first.Priority != 1 || first.Region != "Groningen" {
+		test.Errorf("Got %+v", first)
+	}
+	if !first.Time.Equal(time.Date(2026, 10, 19, 14, 3, 12, 0, DutchTime)) {
+		test.Error("Got time", first.Time)
+	}
+	if strings.Join(first.Capcodes, " ") != "0123310 0100009" {
+		test.Error("Got capcodes", first.Capcodes)
+	}
+	for i, want := range []struct {
+		service  string
+		priority int
+	}{{"brandweer", 1}, {"ambulance", 2}, {"politie", 2}, {"brandweer", 3}, {"ambulance", 3}, {"brandweer", 2}} {
+		if messages[i].Service != want.service || messages[i].Priority != want.priority {
+			test.Errorf("Message %d is %s P%d, expected %s P%d", i,
+				messages[i].Service, messages[i].Priority, want.service, want.priority)
+		}
+	}
+	if messages[5].Text != "P 2 BDH-02 Liftopsluiting Oosterstraat Groningen" {
+		test.Error("Failed markup in a message with", messages[5].Text)
+	}
+
+	messages, err = ParseHTML(fixture(test, "legacy.html"), "Groningen")
+	if err != nil || len(messages) != 3 {
+		test.Fatal("Failed legacy page with", len(messages), err)
+	}
+	if messages[1].Text != "P 1 BDH-01 Gebouwbrand Herestraat Groningen 012331 1420999" ||
+		messages[1].Priority != 1 || messages[1].Region != "Groningen" || !messages[1].Time.IsZero() {
+		test.Errorf("Got %+v", messages[1])
+	}
+
+	if _, err := ParseHTML(fixture(test, "changed.html"), ""); err != ErrNoMessages {
+		test.Error("Changed page gave", err)
+	}
+}
+
+func TestParseRSS(test *testing.T) {
+	messages, err := ParseRSS(fixture(test, "feed.xml"), "Groningen")
+	if err != nil || len(messages) != 2 {
+		test.Fatal("Failed feed with", len(messages), err)
+	}
+	if messages[0].Service != "brandweer" || messages[0].Priority != 1 ||
+		strings.Join(messages[0].Capcodes, " ") != "0123310 0100009" ||
+		messages[0].Time.Unix() != time.Date(2026, 10, 19, 12, 5, 0, 0, time.UTC).Unix() {
+		test.Errorf("Got %+v", messages[0])
+	}
+	if messages[1].Service != "ambulance" || messages[1].Priority != 1 || messages[1].Region != "Groningen" {
+		test.Errorf("Got %+v", messages[1])
+	}
+}
+
+func TestSources(test *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nieuw.html":
+			w.Write(fixture(test, "changed.html"))
+		case "/feed.xml":
+			w.Write(fixture(test, "feed.xml"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source, err := New([]Config{
+		{Url: server.URL + "/weg.html"},
+		{Url: server.URL + "/nieuw.html"},
+		{Url: server.URL + "/feed.xml", Format: "rss"},
+	})
+	if err != nil {
+		test.Fatal(err)
+	}
+	messages, err := source.Messages()
+	if err != nil || len(messages) != 2 {
+		test.Fatal("Failed fallback with", len(messages), err)
+	}
+
+	source, _ = New([]Config{{Url: server.URL + "/nieuw.html"}, {Url: server.URL + "/weg.xml", Format: "rss"}})
+	if _, err := source.Messages(); err == nil {
+		test.Error("No source worked, but no error either")
+	}
+	if _, err := New([]Config{{Url: server.URL, Format: "json"}}); err == nil {
+		test.Error("Unknown format was accepted")
+	}
+}
+
+func TestFilter(test *testing.T) {
+	messages, _ := ParseHTML(fixture(test, "current.html"), "")
+	count := func(filter string) int {
+		f, n := ParseFilter(filter), 0
+		for _, m := range messages {
+			if f.Match(m) {
+				n++
+			}
+		}
+		return n
+	}
+	for filter, want := range map[string]int{
+		"":                    6,
+		"brandweer":           3,
+		"brw p2":              2,
+		"ambulance politie":   3,
+		"P1":                  1,
+		"drenthe":             1,
+		"brandweer groningen": 3,
+		"brandweer assen":     0,
+		"oosterstraat":        1,
+	} {
+		if got := count(filter); got != want {
+			test.Errorf("Filter %q matched %d, expected %d", filter, got, want)
+		}
+	}
+}
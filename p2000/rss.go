@@ -0,0 +1,57 @@
+package p2000
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//A P2000 site's RSS feed, as a fallback for when its pages change. Feeds
+//have the message as the title and the pagers in the description.
+type Feed struct {
+	Url    string
+	Region string
+	//Nil for one that gives up after a few seconds
+	Client *http.Client
+}
+
+func (f *Feed) Messages() ([]Message, error) {
+	body, err := fetch(f.Client, f.Url)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRSS(body, f.Region)
+}
+
+type rssDocument struct {
+	Items []struct {
+		Title       string `xml:"title"`
+		Description string `xml:"description"`
+		PubDate     string `xml:"pubDate"`
+		Category    string `xml:"category"`
+	} `xml:"channel>item"`
+}
+
+//Read the messages in a P2000 feed. The category, if any, is the service.
+func ParseRSS(feed []byte, region string) ([]Message, error) {
+	var doc rssDocument
+	if err := xml.Unmarshal(feed, &doc); err != nil {
+		return nil, err
+	}
+	var messages []Message
+	for _, item := range doc.Items {
+		if strings.TrimSpace(item.Title) == "" {
+			continue
+		}
+		m := Message{Text: item.Title, Capcodes: capcode.FindAllString(item.Description, -1)}
+		if t, err := time.Parse(time.RFC1123Z, strings.TrimSpace(item.PubDate)); err == nil {
+			m.Time = t
+		}
+		messages = append(messages, complete(m, item.Category, region))
+	}
+	if len(messages) == 0 {
+		return nil, ErrNoMessages
+	}
+	return messages, nil
+}
@@ -0,0 +1,298 @@
+//Package shortlink makes long links short. The Store keeps links under short
+//codes in a JSON file and serves the redirects itself; a shortener elsewhere
+//can stand in for it.
+package shortlink
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFile = "shortlinks.json"
+	codeLength  = 6
+	codeChars   = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	//How long we wait for a shortener elsewhere, and how much of its answer
+	//we read
+	remoteTimeout = 5 * time.Second
+	remoteMaxSize = 2048
+	//Links and clicks are saved this long after the first change that wasn't,
+	//not each time, or a popular link would keep rewriting the file
+	saveDelay = 30 * time.Second
+	//How many links the Store keeps at most, in all and for each who asked,
+	//unless the config says otherwise
+	defaultMaxLinks   = 10000
+	defaultMaxPerUser = 100
+)
+
+var (
+	//Only http and https links are shortened
+	ErrNotALink = errors.New("that's not a link")
+	//The Store keeps no more links, or none more for whoever asked
+	ErrFull    = errors.New("no room for more links")
+	ErrTooMany = errors.New("too many links for one person")
+)
+
+type Shortener interface {
+	//Make a short link for the link, on behalf of who asked
+	Shorten(link, by string) (string, error)
+}
+
+//How to shorten links, as in config.json
+type Config struct {
+	//"builtin" (the default) or "remote"
+	Backend string
+	//For builtin: the address the short links start with, where to listen
+	//for them, and where they are kept (shortlinks.json if left empty)
+	BaseUrl string
+	Listen  string
+	File    string
+	//How many links to keep at most, in all and for each who asked
+	MaxLinks   int
+	MaxPerUser int
+	//For remote: the address to ask, with {url} where the link goes
+	Api string
+}
+
+//Make the shortener the config asks for. The Store is nil unless it's the
+//built-in one, which has to be served on Listen.
+func New(conf Config) (Shortener, *Store, error) {
+	switch conf.Backend {
+	case "", "builtin":
+		if conf.BaseUrl == "" {
+			return nil, nil, errors.New("no BaseUrl for the short links")
+		}
+		store := NewStore(conf.File, conf.BaseUrl)
+		if conf.MaxLinks > 0 {
+			store.MaxLinks = conf.MaxLinks
+		}
+		if conf.MaxPerUser > 0 {
+			store.MaxPerUser = conf.MaxPerUser
+		}
+		return store, store, nil
+	case "remote":
+		if !strings.Contains(conf.Api, "{url}") {
+			return nil, nil, errors.New("the Api has no {url}")
+		}
+		return &Remote{Api: conf.Api}, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unknown backend %q", conf.Backend)
+}
+
+//A link we keep, and how it has been doing
+type Link struct {
+	Url       string
+	By        string
+	Created   time.Time
+	Clicks    int
+	LastClick time.Time
+}
+
+//The built-in shortener. Short links are BaseUrl followed by a code; the
+//Store is also the http.Handler that redirects them.
+type Store struct {
+	BaseUrl string
+	//How many links it keeps at most, in all and for each who asked
+	MaxLinks   int
+	MaxPerUser int
+	file       string
+
+	lock  sync.Mutex
+	links map[string]*Link
+	//The code of each link, so a link shortened twice gets the same one
+	codes map[string]string
+	//Whether there are clicks waiting to be saved
+	saving bool
+}
+
+//Open the store kept in file, or in shortlinks.json if that is empty
+func NewStore(file, baseUrl string) *Store {
+	if file == "" {
+		file = defaultFile
+	}
+	s := &Store{
+		BaseUrl:    strings.TrimRight(baseUrl, "/"),
+		MaxLinks:   defaultMaxLinks,
+		MaxPerUser: defaultMaxPerUser,
+		file:       file,
+		links:      make(map[string]*Link),
+		codes:      make(map[string]string),
+	}
+	jsonBlob, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Can't read short links,", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(jsonBlob, &s.links); err != nil {
+		log.Println("Can't parse short links,", err)
+		s.links = make(map[string]*Link)
+	}
+	for code, link := range s.links {
+		s.codes[link.Url] = code
+	}
+	return s
+}
+
+//Call with the lock held
+func (s *Store) saveSoon() {
+	if s.saving {
+		return
+	}
+	s.saving = true
+	time.AfterFunc(saveDelay, s.Flush)
+}
+
+//Save the links and clicks that are waiting now, as before shutting down
+func (s *Store) Flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.saving {
+		s.save()
+	}
+}
+
+//Call with the lock held
+func (s *Store) save() {
+	s.saving = false
+	jsonBlob, err := json.MarshalIndent(s.links, "", "\t")
+	if err == nil {
+		err = ioutil.WriteFile(s.file, jsonBlob, 0644)
+	}
+	if err != nil {
+		log.Println("Can't save short links,", err)
+	}
+}
+
+func (s *Store) Shorten(link, by string) (string, error) {
+	if !isLink(link) {
+		return "", ErrNotALink
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if code, ok := s.codes[link]; ok {
+		return s.BaseUrl + "/" + code, nil
+	}
+	if len(s.links) >= s.MaxLinks {
+		return "", ErrFull
+	}
+	mine := 0
+	for _, l := range s.links {
+		if strings.EqualFold(l.By, by) {
+			mine++
+		}
+	}
+	if mine >= s.MaxPerUser {
+		return "", ErrTooMany
+	}
+	code, err := s.newCode()
+	if err != nil {
+		return "", err
+	}
+	s.links[code] = &Link{Url: link, By: by, Created: time.Now()}
+	s.codes[link] = code
+	s.saveSoon()
+	return s.BaseUrl + "/" + code, nil
+}
+
+//A code nobody has yet. Call with the lock held.
+func (s *Store) newCode() (string, error) {
+	for {
+		code := make([]byte, codeLength)
+		for i := range code {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeChars))))
+			if err != nil {
+				return "", err
+			}
+			code[i] = codeChars[n.Int64()]
+		}
+		if _, taken := s.links[string(code)]; !taken {
+			return string(code), nil
+		}
+	}
+}
+
+//What we know of a short link, by its code or the whole short link
+func (s *Store) Lookup(short string) (code string, link Link, ok bool) {
+	code = strings.TrimPrefix(short, s.BaseUrl+"/")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	found, ok := s.links[code]
+	if !ok {
+		return code, Link{}, false
+	}
+	return code, *found, true
+}
+
+//Send whoever follows a short link on. The redirect is temporary, or
+//browsers would stop coming by and we wouldn't count them.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, "/")
+	s.lock.Lock()
+	link, ok := s.links[code]
+	if ok && r.Method != "HEAD" {
+		link.Clicks++
+		link.LastClick = time.Now()
+		s.saveSoon()
+	}
+	s.lock.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, link.Url, http.StatusFound)
+}
+
+//A shortener elsewhere, that answers a GET with nothing but the short link,
+//such as "https://is.gd/create.php?format=simple&url={url}"
+type Remote struct {
+	Api string
+	//Nil for one that gives up after a few seconds
+	Client *http.Client
+}
+
+func (r *Remote) Shorten(link, by string) (string, error) {
+	if !isLink(link) {
+		return "", ErrNotALink
+	}
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: remoteTimeout}
+	}
+	response, err := client.Get(strings.Replace(r.Api, "{url}", url.QueryEscape(link), -1))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the shortener said %s", response.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, remoteMaxSize))
+	if err != nil {
+		return "", err
+	}
+	//Whatever else it is, an error page is no short link
+	short := strings.TrimSpace(string(body))
+	if !isLink(short) || strings.ContainsAny(short, " \n<>") {
+		return "", errors.New("the shortener didn't answer with a link")
+	}
+	return short, nil
+}
+
+func isLink(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,127 @@
+package shortlink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(test *testing.T) {
+	dir, _ := ioutil.TempDir("", "shortlink")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "links.json")
+	s := NewStore(file, "https://eppo.nl/s/")
+
+	short, err := s.Shorten("https://example.com/een/heel/lang/pad", "erik")
+	if err != nil || !strings.HasPrefix(short, "https://eppo.nl/s/") || len(short) != len("https://eppo.nl/s/")+codeLength {
+		test.Fatal("Failed shorten with", short, err)
+	}
+	if again, _ := s.Shorten("https://example.com/een/heel/lang/pad", "harm"); again != short {
+		test.Error("Shortened the same link to", again)
+	}
+	if other, _ := s.Shorten("https://example.com/iets/anders", "harm"); other == short {
+		test.Error("Shortened another link to the same", other)
+	}
+	for _, link := range []string{"ftp://example.com/", "example.com", "http://"} {
+		if _, err := s.Shorten(link, "erik"); err != ErrNotALink {
+			test.Error("Shortened", link, "with", err)
+		}
+	}
+
+	// Following it, which counts
+	server := httptest.NewServer(s)
+	defer server.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	code := strings.TrimPrefix(short, "https://eppo.nl/s/")
+	for i := 0; i < 2; i++ {
+		response, err := client.Get(server.URL + "/" + code)
+		if err != nil || response.StatusCode != http.StatusFound ||
+			response.Header.Get("Location") != "https://example.com/een/heel/lang/pad" {
+			test.Fatal("Failed redirect with", response, err)
+		}
+		response.Body.Close()
+	}
+	if response, _ := client.Get(server.URL + "/onbekend"); response.StatusCode != http.StatusNotFound {
+		test.Error("Redirected an unknown code with", response.Status)
+	}
+
+	// Links and clicks are saved a while later, not every time
+	if _, _, ok := NewStore(file, "https://eppo.nl/s").Lookup(code); ok {
+		test.Error("Saved right away")
+	}
+	s.Flush()
+
+	// It's all still there after a restart
+	s = NewStore(file, "https://eppo.nl/s")
+	if got, link, ok := s.Lookup(short); !ok || got != code || link.Clicks != 2 || link.By != "erik" || link.LastClick.IsZero() {
+		test.Errorf("Failed lookup with %s %+v %v", got, link, ok)
+	}
+	if _, link, ok := s.Lookup(code); !ok || link.Url != "https://example.com/een/heel/lang/pad" {
+		test.Errorf("Failed lookup by code with %+v %v", link, ok)
+	}
+	if again, _ := s.Shorten("https://example.com/een/heel/lang/pad", "harm"); again != short {
+		test.Error("Shortened the same link after a restart to", again)
+	}
+
+	// There's only so much room, for each and for all
+	s.MaxPerUser = 2
+	if _, err := s.Shorten("https://example.com/nog/een", "Harm"); err != nil {
+		test.Error("Failed second link with", err)
+	}
+	if _, err := s.Shorten("https://example.com/te/veel", "harm"); err != ErrTooMany {
+		test.Error("Failed third link with", err)
+	}
+	s.MaxLinks = 3
+	if _, err := s.Shorten("https://example.com/vol", "erik"); err != ErrFull {
+		test.Error("Failed full store with", err)
+	}
+}
+
+func TestRemote(test *testing.T) {
+	answer, status := "", http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("url") != "https://example.com/?a=b&c=d" {
+			test.Error("Asked to shorten", r.FormValue("url"))
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, answer)
+	}))
+	defer server.Close()
+	remote := &Remote{Api: server.URL + "/create?format=simple&url={url}"}
+
+	answer = "https://kort.nl/abc\n"
+	if short, err := remote.Shorten("https://example.com/?a=b&c=d", "erik"); err != nil || short != "https://kort.nl/abc" {
+		test.Error("Failed shorten with", short, err)
+	}
+	// Error pages are no short links
+	answer, status = "<html><body>Oeps</body></html>", http.StatusInternalServerError
+	if short, err := remote.Shorten("https://example.com/?a=b&c=d", "erik"); err == nil {
+		test.Error("Shortened to", short)
+	}
+	status = http.StatusOK
+	if short, err := remote.Shorten("https://example.com/?a=b&c=d", "erik"); err == nil {
+		test.Error("Shortened to", short)
+	}
+}
+
+func TestNew(test *testing.T) {
+	if _, _, err := New(Config{}); err == nil {
+		test.Error("Made a store without BaseUrl")
+	}
+	if _, _, err := New(Config{Backend: "remote", Api: "https://is.gd/create.php"}); err == nil {
+		test.Error("Made a remote without {url}")
+	}
+	if _, _, err := New(Config{Backend: "nazr.in"}); err == nil {
+		test.Error("Made an unknown backend")
+	}
+	if shortener, store, err := New(Config{Backend: "remote", Api: "https://is.gd/create.php?url={url}"}); err != nil || store != nil || shortener == nil {
+		test.Error("Failed remote with", shortener, store, err)
+	}
+}
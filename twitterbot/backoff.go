@@ -0,0 +1,81 @@
+package twitterbot
+
+import (
+	"time"
+)
+
+// How long to wait before connecting again, after the connection failed. These
+// are the rules Twitter asks streaming clients to follow: back off linearly
+// for network errors, exponentially for HTTP errors, and start at a minute
+// when we are being rate limited. They are just as polite to everyone else.
+type backoff struct {
+	kind     int
+	wait     time.Duration
+	failures int
+}
+
+const (
+	networkError = iota + 1
+	httpError
+	rateLimited
+)
+
+const (
+	networkStep = 250 * time.Millisecond
+	networkMax  = 16 * time.Second
+	httpStart   = 5 * time.Second
+	httpMax     = 320 * time.Second
+	rateStart   = time.Minute
+	rateMax     = 16 * time.Minute
+)
+
+// Register a failure and return how long to wait before trying again
+func (bo *backoff) fail(err error) time.Duration {
+	kind := networkError
+	switch e := err.(type) {
+	case *StatusError:
+		kind = httpError
+		if e.Code == 420 || e.Code == 429 {
+			kind = rateLimited
+		}
+	case *DisconnectError:
+		//Someone else is using our stream; don't fight over it
+		if e.Code == disconnectDuplicate {
+			kind = httpError
+		}
+	}
+	//Start over if things went wrong in a different way
+	if kind != bo.kind {
+		bo.kind = kind
+		bo.wait = 0
+	}
+	bo.failures++
+
+	switch kind {
+	case networkError:
+		bo.wait += networkStep
+		if bo.wait > networkMax {
+			bo.wait = networkMax
+		}
+	case httpError:
+		bo.wait = double(bo.wait, httpStart, httpMax)
+	case rateLimited:
+		bo.wait = double(bo.wait, rateStart, rateMax)
+	}
+	return bo.wait
+}
+
+// Things work again
+func (bo *backoff) reset() {
+	*bo = backoff{}
+}
+
+func double(wait, start, max time.Duration) time.Duration {
+	if wait == 0 {
+		return start
+	}
+	if wait*2 > max {
+		return max
+	}
+	return wait * 2
+}
@@ -0,0 +1,195 @@
+package twitterbot
+
+import (
+	"code.google.com/p/go.net/websocket"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type BlueskyConfig struct {
+	//The firehose, through a Jetstream instance, which turns it into JSON.
+	//Defaults to one of the public ones run by Bluesky.
+	Jetstream string
+	//Where to look up profiles. Defaults to the public Bluesky API.
+	Api string
+}
+
+const (
+	defaultJetstream  = "wss://jetstream2.us-east.bsky.network/subscribe"
+	defaultBlueskyApi = "https://public.api.bsky.app"
+	//getProfiles takes no more than this many at once
+	blueskyProfileBatch = 25
+)
+
+type blueskyProfile struct {
+	Did         string
+	Handle      string
+	DisplayName string
+}
+
+func (p blueskyProfile) user() User {
+	return User{Name: p.DisplayName, Screen_Name: p.Handle, Id_Str: p.Did}
+}
+
+//One event from Jetstream; we only care about new and deleted posts
+type jetstreamEvent struct {
+	Did    string
+	Kind   string
+	Commit struct {
+		Operation  string
+		Collection string
+		Rkey       string
+		Record     struct {
+			Text string
+		}
+	}
+}
+
+//Relays posts by followed accounts from the Bluesky firehose. Accounts are
+//followed by their DID.
+type blueskySource struct {
+	conf BlueskyConfig
+}
+
+func newBlueskySource(conf BlueskyConfig) *blueskySource {
+	if conf.Jetstream == "" {
+		conf.Jetstream = defaultJetstream
+	}
+	if conf.Api == "" {
+		conf.Api = defaultBlueskyApi
+	}
+	conf.Api = strings.TrimRight(conf.Api, "/")
+	return &blueskySource{conf: conf}
+}
+
+func (s *blueskySource) Connect(follow []string) (ItemStream, error) {
+	if len(follow) == 0 {
+		//Without wantedDids, we'd get everything on Bluesky
+		return newIdleStream(), nil
+	}
+	//The firehose only knows DIDs, so look up the handles to show
+	users, err := s.LookupUsers(follow)
+	if err != nil {
+		return nil, err
+	}
+	handles := make(map[string]string)
+	for _, u := range users {
+		handles[u.Id_Str] = u.Screen_Name
+	}
+
+	params := url.Values{
+		"wantedCollections": {"app.bsky.feed.post"},
+		"wantedDids":        follow,
+	}
+	conn, err := websocket.Dial(s.conf.Jetstream+"?"+params.Encode(), "", "http://localhost/")
+	if err != nil {
+		return nil, err
+	}
+	return &blueskyStream{conn: conn, handles: handles}, nil
+}
+
+//Following happens by DID in the firehose, nothing to tell anyone
+func (s *blueskySource) Follow(user User) error   { return nil }
+func (s *blueskySource) Unfollow(user User) error { return nil }
+
+func (s *blueskySource) LookupUser(name string) (User, error) {
+	var profile blueskyProfile
+	err := s.call("app.bsky.actor.getProfile",
+		url.Values{"actor": {strings.TrimPrefix(name, "@")}}, &profile)
+	return profile.user(), err
+}
+
+func (s *blueskySource) LookupUsers(ids []string) ([]User, error) {
+	users := make([]User, 0, len(ids))
+	for start := 0; start < len(ids); start += blueskyProfileBatch {
+		end := start + blueskyProfileBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var result struct {
+			Profiles []blueskyProfile
+		}
+		if err := s.call("app.bsky.actor.getProfiles",
+			url.Values{"actors": ids[start:end]}, &result); err != nil {
+			return nil, err
+		}
+		for _, p := range result.Profiles {
+			users = append(users, p.user())
+		}
+	}
+	return users, nil
+}
+
+func (s *blueskySource) Permalink(item *Item) string {
+	return "https://bsky.app/profile/" + item.User.Screen_Name + "/post/" + item.Id
+}
+
+func (s *blueskySource) call(method string, params url.Values, result interface{}) error {
+	response, err := restClient.Get(s.conf.Api + "/xrpc/" + method + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return &StatusError{response.StatusCode, fmt.Sprintf("%s: %s", method, response.Status)}
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+type blueskyStream struct {
+	conn    *websocket.Conn
+	handles map[string]string
+}
+
+func (s *blueskyStream) Next() (*Item, error) {
+	var event jetstreamEvent
+	if err := websocket.JSON.Receive(s.conn, &event); err != nil {
+		return nil, err
+	}
+	if event.Kind != "commit" || event.Commit.Collection != "app.bsky.feed.post" {
+		return nil, nil
+	}
+	handle, ok := s.handles[event.Did]
+	if !ok {
+		handle = event.Did
+	}
+	switch event.Commit.Operation {
+	case "create":
+		return &Item{
+			Id:   event.Commit.Rkey,
+			User: User{Screen_Name: handle, Id_Str: event.Did},
+			Text: event.Commit.Record.Text,
+		}, nil
+	case "delete":
+		return &Item{
+			Id:      event.Commit.Rkey,
+			User:    User{Screen_Name: handle, Id_Str: event.Did},
+			Deleted: true,
+		}, nil
+	}
+	return nil, nil
+}
+
+func (s *blueskyStream) Close() error {
+	return s.conn.Close()
+}
+
+//A stream on which nothing ever happens, until it is closed
+type idleStream chan bool
+
+func newIdleStream() idleStream {
+	return make(idleStream)
+}
+
+func (s idleStream) Next() (*Item, error) {
+	<-s
+	return nil, fmt.Errorf("stream closed")
+}
+
+func (s idleStream) Close() error {
+	close(s)
+	return nil
+}
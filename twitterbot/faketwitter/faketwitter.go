@@ -0,0 +1,499 @@
+//Package faketwitter pretends to be Twitter, well enough to run the
+//twitterbot against it: the streaming API, looking up users, tweeting and the
+//OAuth 1.0a and 2.0 dances of twittersetup. Point the Twitter section of
+//twitter.json at it.
+package faketwitter
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//The verification code that the authorize page hands out
+const Verifier = "1234"
+
+type User struct {
+	Name        string `json:"name"`
+	Screen_Name string `json:"screen_name"`
+	Id_Str      string `json:"id_str"`
+}
+
+type Tweet struct {
+	Id_Str                  string `json:"id_str"`
+	Text                    string `json:"text"`
+	User                    User   `json:"user"`
+	In_Reply_To_User_Id_Str string `json:"in_reply_to_user_id_str,omitempty"`
+}
+
+type Server struct {
+	//Who may come in, as in twitter.json. The access token is handed out by
+	//the OAuth dance too.
+	ConsumerKey string
+	Token       string
+	//The OAuth 2.0 client that may log users in with PKCE
+	ClientId string
+	//The account the token belongs to, which tweets what is posted
+	Account User
+	//Gets the follow parameter of every new stream, if there is room
+	Connects chan string
+
+	lock    sync.Mutex
+	users   []User
+	tweets  map[string]Tweet
+	streams map[*stream]bool
+	lastId  int
+	//Bearer tokens handed out, and whether they are for the account rather
+	//than the app
+	bearers       map[string]bool
+	refreshTokens map[string]bool
+	//PKCE codes handed out, with their challenge
+	codes   map[string]string
+	revoked bool
+}
+
+//An open connection to the streaming API
+type stream struct {
+	follow map[string]bool
+	lines  chan []byte
+}
+
+func New(consumerKey, token string) *Server {
+	s := &Server{
+		ConsumerKey:   consumerKey,
+		Token:         token,
+		ClientId:      "client",
+		Account:       User{Name: "Jan Eppo", Screen_Name: "janeppo", Id_Str: "1"},
+		Connects:      make(chan string, 10),
+		tweets:        make(map[string]Tweet),
+		streams:       make(map[*stream]bool),
+		lastId:        1000,
+		bearers:       make(map[string]bool),
+		refreshTokens: make(map[string]bool),
+		codes:         make(map[string]string),
+	}
+	s.users = append(s.users, s.Account)
+	return s
+}
+
+func (s *Server) AddUser(screenName, id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.users = append(s.users, User{Name: screenName, Screen_Name: screenName, Id_Str: id})
+}
+
+//Find a user by screen name or id. Call with the lock held.
+func (s *Server) user(screenName, id string) (User, bool) {
+	for _, user := range s.users {
+		if (screenName != "" && strings.EqualFold(user.Screen_Name, screenName)) || (id != "" && user.Id_Str == id) {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+func (s *Server) newId() string {
+	s.lastId++
+	return strconv.Itoa(s.lastId)
+}
+
+//Tweet as a user, who is made up if we don't know them yet. Returns the id
+//of the tweet.
+func (s *Server) Tweet(screenName, text string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.user(screenName, "")
+	if !ok {
+		user = User{Name: screenName, Screen_Name: screenName, Id_Str: s.newId()}
+		s.users = append(s.users, user)
+	}
+	return s.tweet(Tweet{Text: text, User: user}).Id_Str
+}
+
+//Call with the lock held
+func (s *Server) tweet(tweet Tweet) Tweet {
+	tweet.Id_Str = s.newId()
+	s.tweets[tweet.Id_Str] = tweet
+	s.broadcast(tweet, tweet.User.Id_Str, tweet.In_Reply_To_User_Id_Str)
+	return tweet
+}
+
+//Delete a tweet, as its author would
+func (s *Server) Delete(id string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.delete(id)
+}
+
+//Call with the lock held
+func (s *Server) delete(id string) bool {
+	tweet, ok := s.tweets[id]
+	if !ok {
+		return false
+	}
+	delete(s.tweets, id)
+	notice := map[string]interface{}{"delete": map[string]interface{}{
+		"status": map[string]string{"id_str": id, "user_id_str": tweet.User.Id_Str},
+	}}
+	s.broadcast(notice, tweet.User.Id_Str)
+	return true
+}
+
+//Send a message to the streams that follow any of the users. Streams that
+//can't keep up miss it, as they would on Twitter. Call with the lock held.
+func (s *Server) broadcast(message interface{}, users ...string) {
+	line, _ := json.Marshal(message)
+	line = append(line, '\r', '\n')
+	for st := range s.streams {
+		if !st.follows(users) {
+			continue
+		}
+		select {
+		case st.lines <- line:
+		default:
+		}
+	}
+}
+
+func (st *stream) follows(users []string) bool {
+	for _, id := range users {
+		if st.follow[id] {
+			return true
+		}
+	}
+	return false
+}
+
+//Close every stream, as Twitter does now and then
+func (s *Server) Disconnect() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for st := range s.streams {
+		close(st.lines)
+		delete(s.streams, st)
+	}
+}
+
+//Make the access tokens of users run out, as they do after two hours. Their
+//refresh tokens still work.
+func (s *Server) ExpireTokens() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for token, user := range s.bearers {
+		if user {
+			delete(s.bearers, token)
+		}
+	}
+}
+
+//Take back every token, as when the account revokes the app. Open streams
+//are told so and closed.
+func (s *Server) Revoke() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.revoked = true
+	s.bearers = make(map[string]bool)
+	s.refreshTokens = make(map[string]bool)
+	line, _ := json.Marshal(map[string]interface{}{"disconnect": map[string]interface{}{
+		"code": 6, "stream_name": s.Account.Screen_Name, "reason": "token revoked",
+	}})
+	for st := range s.streams {
+		select {
+		case st.lines <- append(line, '\r', '\n'):
+		default:
+		}
+		close(st.lines)
+		delete(s.streams, st)
+	}
+}
+
+//Hand out a bearer token, for the account or for the app. Call with the
+//lock held.
+func (s *Server) newBearer(user bool) string {
+	token := "bearer-" + s.newId()
+	s.bearers[token] = user
+	return token
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/oauth/request_token":
+		fmt.Fprint(w, "oauth_token=request&oauth_token_secret=requestsecret&oauth_callback_confirmed=true")
+		return
+	case "/oauth/authorize":
+		fmt.Fprintf(w, "Your PIN is %s\n", Verifier)
+		return
+	case "/oauth/access_token":
+		params := oauthParams(r)
+		if params["oauth_token"] != "request" || params["oauth_verifier"] != Verifier {
+			fail(w, http.StatusUnauthorized, 89, "Invalid or expired token.")
+			return
+		}
+		s.lock.Lock()
+		s.revoked = false
+		s.lock.Unlock()
+		fmt.Fprint(w, url.Values{
+			"oauth_token":        {s.Token},
+			"oauth_token_secret": {"secret"},
+			"user_id":            {s.Account.Id_Str},
+			"screen_name":        {s.Account.Screen_Name},
+		}.Encode())
+		return
+	case "/oauth2/token":
+		s.serveAppToken(w, r)
+		return
+	case "/i/oauth2/authorize":
+		s.serveAuthorize(w, r)
+		return
+	case "/2/oauth2/token":
+		s.serveUserToken(w, r)
+		return
+	}
+
+	//Everything else needs a token, and some things a token of the account
+	user, ok := s.authorized(r)
+	if !ok {
+		fail(w, http.StatusUnauthorized, 89, "Invalid or expired token.")
+		return
+	}
+	switch r.URL.Path {
+	case "/1.1/statuses/filter.json", "/1.1/statuses/update.json", "/1.1/account/verify_credentials.json", "/2/users/me":
+		if !user {
+			fail(w, http.StatusForbidden, 220, "Your credentials do not allow access to this resource.")
+			return
+		}
+	}
+	if strings.HasPrefix(r.URL.Path, "/1.1/statuses/destroy/") && !user {
+		fail(w, http.StatusForbidden, 220, "Your credentials do not allow access to this resource.")
+		return
+	}
+	switch {
+	case r.URL.Path == "/1.1/statuses/filter.json":
+		s.serveStream(w, r)
+	case r.URL.Path == "/1.1/account/verify_credentials.json":
+		json.NewEncoder(w).Encode(s.Account)
+	case r.URL.Path == "/2/users/me":
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{
+			"id": s.Account.Id_Str, "name": s.Account.Name, "username": s.Account.Screen_Name,
+		}})
+	case r.URL.Path == "/1.1/application/rate_limit_status.json":
+		fmt.Fprint(w, `{"resources":{}}`)
+	case r.URL.Path == "/1.1/users/show.json":
+		s.lock.Lock()
+		user, ok := s.user(r.FormValue("screen_name"), r.FormValue("user_id"))
+		s.lock.Unlock()
+		if !ok {
+			fail(w, http.StatusNotFound, 50, "User not found.")
+			return
+		}
+		json.NewEncoder(w).Encode(user)
+	case r.URL.Path == "/1.1/users/lookup.json":
+		ids := strings.Split(r.FormValue("user_id"), ",")
+		if len(ids) > 100 {
+			fail(w, http.StatusForbidden, 18, "Too many terms specified in query.")
+			return
+		}
+		var users []User
+		s.lock.Lock()
+		for _, id := range ids {
+			if user, ok := s.user("", id); ok {
+				users = append(users, user)
+			}
+		}
+		for _, name := range strings.Split(r.FormValue("screen_name"), ",") {
+			if user, ok := s.user(name, ""); ok {
+				users = append(users, user)
+			}
+		}
+		s.lock.Unlock()
+		if len(users) == 0 {
+			fail(w, http.StatusNotFound, 17, "No user matches for specified terms.")
+			return
+		}
+		json.NewEncoder(w).Encode(users)
+	case r.URL.Path == "/1.1/statuses/update.json" && r.Method == "POST":
+		s.lock.Lock()
+		tweet := s.tweet(Tweet{Text: r.FormValue("status"), User: s.Account})
+		s.lock.Unlock()
+		json.NewEncoder(w).Encode(tweet)
+	case strings.HasPrefix(r.URL.Path, "/1.1/statuses/destroy/") && r.Method == "POST":
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/1.1/statuses/destroy/"), ".json")
+		s.lock.Lock()
+		tweet, ok := s.tweets[id]
+		ok = ok && tweet.User.Id_Str == s.Account.Id_Str && s.delete(id)
+		s.lock.Unlock()
+		if !ok {
+			fail(w, http.StatusNotFound, 144, "No status found with that ID.")
+			return
+		}
+		json.NewEncoder(w).Encode(tweet)
+	default:
+		fail(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+	}
+}
+
+//Whether the request has a token we handed out, and whether that is a token
+//of the account. For OAuth 1.0a, we don't check the signature, only who
+//signed.
+func (s *Server) authorized(r *http.Request) (user bool, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		user, ok = s.bearers[strings.TrimPrefix(header, "Bearer ")]
+		return user, ok
+	}
+	params := oauthParams(r)
+	ok = !s.revoked && params["oauth_consumer_key"] == s.ConsumerKey &&
+		params["oauth_token"] == s.Token && params["oauth_signature"] != ""
+	return true, ok
+}
+
+//A bearer token for the app, for its consumer key and secret
+func (s *Server) serveAppToken(w http.ResponseWriter, r *http.Request) {
+	key, _, ok := r.BasicAuth()
+	key, _ = url.QueryUnescape(key)
+	if !ok || key != s.ConsumerKey || r.Method != "POST" || r.FormValue("grant_type") != "client_credentials" {
+		fail(w, http.StatusForbidden, 99, "Unable to verify your credentials")
+		return
+	}
+	s.lock.Lock()
+	token := s.newBearer(false)
+	s.lock.Unlock()
+	json.NewEncoder(w).Encode(map[string]string{"token_type": "bearer", "access_token": token})
+}
+
+//Where the user allows the app. Nobody has to log in; the browser is sent
+//straight back with a code.
+func (s *Server) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	redirect, err := url.Parse(r.FormValue("redirect_uri"))
+	if err != nil || redirect.Scheme == "" || r.FormValue("client_id") != s.ClientId ||
+		r.FormValue("response_type") != "code" || r.FormValue("code_challenge_method") != "S256" {
+		http.Error(w, "Something went wrong. You weren't able to give access to the App.", http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	code := "code-" + s.newId()
+	s.codes[code] = r.FormValue("code_challenge")
+	s.lock.Unlock()
+	query := redirect.Query()
+	query.Set("code", code)
+	query.Set("state", r.FormValue("state"))
+	redirect.RawQuery = query.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+//A token for the account, for a PKCE code or a refresh token
+func (s *Server) serveUserToken(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ok := false
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		challenge, known := s.codes[r.FormValue("code")]
+		delete(s.codes, r.FormValue("code"))
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		ok = known && r.FormValue("client_id") == s.ClientId && base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+	case "refresh_token":
+		ok = s.refreshTokens[r.FormValue("refresh_token")]
+		delete(s.refreshTokens, r.FormValue("refresh_token"))
+	}
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_request","error_description":"Value passed for the token was invalid."}`)
+		return
+	}
+	refresh := "refresh-" + s.newId()
+	s.refreshTokens[refresh] = true
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token_type":    "bearer",
+		"access_token":  s.newBearer(true),
+		"refresh_token": refresh,
+		"expires_in":    7200,
+		"scope":         r.FormValue("scope"),
+	})
+}
+
+//Keep sending what the followed users tweet, until either side hangs up
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		fail(w, http.StatusInternalServerError, 131, "Internal error")
+		return
+	}
+	follow := r.FormValue("follow")
+	st := &stream{follow: make(map[string]bool), lines: make(chan []byte, 100)}
+	for _, id := range strings.Split(follow, ",") {
+		st.follow[id] = true
+	}
+	s.lock.Lock()
+	s.streams[st] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.streams, st)
+		s.lock.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	select {
+	case s.Connects <- follow:
+	default:
+	}
+	for {
+		select {
+		case line, ok := <-st.lines:
+			if !ok {
+				return
+			}
+			w.Write(line)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+//The oauth_ parameters of a request, from the Authorization header or else
+//from the form
+func oauthParams(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	r.ParseForm()
+	for key, values := range r.Form {
+		if strings.HasPrefix(key, "oauth_") {
+			params[key] = values[0]
+		}
+	}
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "OAuth ") {
+		return params
+	}
+	for _, param := range strings.Split(header[6:], ",") {
+		parts := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := url.QueryUnescape(strings.Trim(parts[1], `"`))
+		if err == nil {
+			params[parts[0]] = value
+		}
+	}
+	return params
+}
+
+//Answer with an error the way Twitter does
+func fail(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]interface{}{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,192 @@
+package twitterbot
+
+import (
+	"../catalog"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+//What to relay of a followed account. Accounts without a filter get
+//defaultFilter.
+type Filter struct {
+	//Replies by the account to others; threads of its own always go
+	Replies  bool
+	Retweets bool
+	Quotes   bool
+	//Replies and retweets by others, to or of the account
+	Others bool
+	//If there are any, only relay what has one of these words
+	Include []string
+	//Never relay what has one of these words
+	Exclude []string
+}
+
+var defaultFilter = Filter{Replies: true, Retweets: true, Quotes: true}
+
+//Whatever a filter in twitter.json leaves out is as in defaultFilter
+func (f *Filter) UnmarshalJSON(data []byte) error {
+	type plain Filter
+	p := plain(defaultFilter)
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*f = Filter(p)
+	return nil
+}
+
+//A change to a filter. Nil fields stay as they are.
+type FilterChange struct {
+	Replies, Retweets, Quotes, Others *bool
+	//A word to add to Include or Exclude
+	Include, Exclude string
+	//Forget all words
+	Clear bool
+}
+
+func (f *Filter) apply(c *FilterChange) {
+	//Forgetting comes first, or the words given along with it would go too
+	if c.Clear {
+		f.Include, f.Exclude = nil, nil
+	}
+	for _, setting := range []struct {
+		field *bool
+		value *bool
+	}{{&f.Replies, c.Replies}, {&f.Retweets, c.Retweets}, {&f.Quotes, c.Quotes}, {&f.Others, c.Others}} {
+		if setting.value != nil {
+			*setting.field = *setting.value
+		}
+	}
+	if c.Include != "" {
+		f.Include = append(f.Include, strings.ToLower(c.Include))
+	}
+	if c.Exclude != "" {
+		f.Exclude = append(f.Exclude, strings.ToLower(c.Exclude))
+	}
+}
+
+//Whether the words in the item pass the filter
+func (f *Filter) matches(item *Item) bool {
+	text := item.Text
+	if item.Quote != nil {
+		text += " " + item.Quote.Text
+	}
+	text = strings.ToLower(text)
+	for _, word := range f.Exclude {
+		if strings.Contains(text, word) {
+			return false
+		}
+	}
+	for _, word := range f.Include {
+		if strings.Contains(text, word) {
+			return true
+		}
+	}
+	return len(f.Include) == 0
+}
+
+func (f *Followed) filter() Filter {
+	if f.Filter != nil {
+		return *f.Filter
+	}
+	return defaultFilter
+}
+
+//Whether to relay an item, and where to. The stream also has what others do
+//with the tweets of followed accounts; that goes by the filter of the
+//followed account, and to its channels.
+func (b *TwitterBot) wanted(item *Item) ([]string, bool) {
+	//Sources that relay everyone when nobody is followed
+	if len(b.following()) == 0 {
+		return nil, true
+	}
+
+	if followed, ok := b.followed(item.User.Id_Str); ok {
+		f := followed.filter()
+		switch {
+		case item.Retweet != nil && !f.Retweets,
+			item.Quote != nil && !f.Quotes,
+			item.ReplyTo != "" && item.ReplyTo != item.User.Id_Str && !f.Replies:
+			return nil, false
+		}
+		return followed.Channels, f.matches(item)
+	}
+
+	related := []string{item.ReplyTo}
+	for _, other := range []*Item{item.Retweet, item.Quote} {
+		if other != nil {
+			related = append(related, other.User.Id_Str)
+		}
+	}
+	for _, id := range related {
+		if followed, ok := b.followed(id); ok {
+			if f := followed.filter(); f.Others && f.matches(item) {
+				return followed.Channels, true
+			}
+		}
+	}
+	return nil, false
+}
+
+//How an item looks on IRC
+func format(item *Item) string {
+	header := "@" + item.User.Screen_Name
+	if item.Retweet != nil {
+		header += " RT @" + item.Retweet.User.Screen_Name
+	}
+	text := item.Text
+	if item.Quote != nil {
+		text += fmt.Sprintf(" [QT @%s: %s]", item.Quote.User.Screen_Name, item.Quote.Text)
+	}
+	return fmt.Sprintf("[%s] %s", header, text)
+}
+
+func (b *TwitterBot) SetFilter(r Request) {
+	user, err := b.lookupUser(r.Arg)
+	if err != nil {
+		log.Println("twb:", err)
+		r.reply(b.text("twitter.unknown"))
+		return
+	}
+	followed, ok := b.followed(user.Id_Str)
+	if !ok {
+		r.reply(b.text("twitter.not_following"))
+		return
+	}
+
+	f := followed.filter()
+	if r.Filter != nil {
+		f.apply(r.Filter)
+		b.lock.Lock()
+		if stored := b.Config.Follow.find(user.Id_Str); stored != nil {
+			stored.Filter = &f
+		}
+		b.lock.Unlock()
+		b.SaveConfig()
+	}
+	r.reply(b.describeFilter(user.Screen_Name, f))
+}
+
+func (b *TwitterBot) describeFilter(name string, f Filter) string {
+	onOff := func(on bool) string {
+		if on {
+			return b.text("twitter.filter.on")
+		}
+		return b.text("twitter.filter.off")
+	}
+	text := b.textWith("twitter.filter", catalog.Params{
+		"user":     name,
+		"replies":  onOff(f.Replies),
+		"retweets": onOff(f.Retweets),
+		"quotes":   onOff(f.Quotes),
+		"others":   onOff(f.Others),
+	})
+	if len(f.Include) > 0 {
+		text += " " + b.textWith("twitter.filter.include", catalog.Params{"words": strings.Join(f.Include, ", ")})
+	}
+	if len(f.Exclude) > 0 {
+		text += " " + b.textWith("twitter.filter.exclude", catalog.Params{"words": strings.Join(f.Exclude, ", ")})
+	}
+	return text
+}
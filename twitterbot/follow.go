@@ -0,0 +1,199 @@
+package twitterbot
+
+import (
+	"../catalog"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
+
+//An account the bot follows
+type Followed struct {
+	Id          string
+	Screen_Name string
+	//Who asked to follow it, and when
+	AddedBy string
+	AddedAt time.Time
+	//Where to relay it; the main channel if there are none
+	Channels []string
+	//What to relay of it; defaultFilter if there is none
+	Filter *Filter `json:",omitempty"`
+}
+
+//The accounts to follow. Older versions of twitter.json have a string of
+//ids separated by commas instead, which is read as well.
+type FollowList []*Followed
+
+func (l *FollowList) UnmarshalJSON(data []byte) error {
+	var ids string
+	if err := json.Unmarshal(data, &ids); err == nil {
+		*l = nil
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				*l = append(*l, &Followed{Id: id})
+			}
+		}
+		return nil
+	}
+	var list []*Followed
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+//Bring a config from before Follow was a list up to date. Tells whether
+//there was anything to do. Call with the lock held.
+func (c *Config) migrate(raw []byte) bool {
+	var old struct {
+		Follow json.RawMessage
+	}
+	json.Unmarshal(raw, &old)
+	migrated := strings.HasPrefix(strings.TrimSpace(string(old.Follow)), `"`)
+	for id, f := range c.Filters {
+		if followed := c.Follow.find(id); followed != nil && f != nil {
+			followed.Filter = f
+		}
+		migrated = true
+	}
+	c.Filters = nil
+	return migrated
+}
+
+func (l FollowList) find(id string) *Followed {
+	for _, followed := range l {
+		if followed.Id == id {
+			return followed
+		}
+	}
+	return nil
+}
+
+//The ids of the followed accounts
+func (b *TwitterBot) following() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ids := make([]string, 0, len(b.Config.Follow))
+	for _, followed := range b.Config.Follow {
+		ids = append(ids, followed.Id)
+	}
+	return ids
+}
+
+//A copy of what we know of a followed account, if we follow it
+func (b *TwitterBot) followed(id string) (Followed, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if followed := b.Config.Follow.find(id); followed != nil {
+		return *followed, true
+	}
+	return Followed{}, false
+}
+
+//Tell whoever asked that we couldn't find the user, or why
+func (b *TwitterBot) lookupFailed(r Request, err error) {
+	log.Println("twb:", err)
+	if needsSetup(err) {
+		r.reply(b.textWith("twitter.unauthorized", catalog.Params{"prefix": r.prefix()}))
+		return
+	}
+	r.reply(b.text("twitter.unknown"))
+}
+
+func (b *TwitterBot) AddTwit(r Request) bool {
+	user, err := b.lookupUser(r.Arg)
+	if err != nil {
+		b.lookupFailed(r, err)
+		return false
+	}
+	if _, ok := b.followed(user.Id_Str); ok {
+		r.reply(b.text("twitter.following"))
+		return false
+	}
+	if err := b.feed().Follow(user); err != nil {
+		log.Println("twb: Can't follow,", err)
+		r.reply(b.text("twitter.unknown"))
+		return false
+	}
+	b.lock.Lock()
+	b.Config.Follow = append(b.Config.Follow, &Followed{
+		Id:          user.Id_Str,
+		Screen_Name: user.Screen_Name,
+		AddedBy:     r.Sender,
+		AddedAt:     time.Now(),
+		Channels:    r.Channels,
+	})
+	b.lock.Unlock()
+	b.SaveConfig()
+	return true
+}
+
+func (b *TwitterBot) DelTwit(r Request) bool {
+	user, err := b.lookupUser(r.Arg)
+	if err != nil {
+		b.lookupFailed(r, err)
+		return false
+	}
+	b.lock.Lock()
+	follows := make(FollowList, 0, len(b.Config.Follow))
+	for _, followed := range b.Config.Follow {
+		if followed.Id != user.Id_Str {
+			follows = append(follows, followed)
+		}
+	}
+	if len(follows) == len(b.Config.Follow) {
+		b.lock.Unlock()
+		r.reply(b.text("twitter.not_following"))
+		return false
+	}
+	b.Config.Follow = follows
+	b.lock.Unlock()
+	if err := b.feed().Unfollow(user); err != nil {
+		log.Println("twb: Can't unfollow,", err)
+	}
+	b.SaveConfig()
+	return true
+}
+
+func (b *TwitterBot) ListTwits(r Request) {
+	ids := b.following()
+	if len(ids) == 0 {
+		r.reply(b.text("twitter.list.none"))
+		return
+	}
+	users, err := b.lookupUsers(ids)
+	if err != nil {
+		r.reply(b.textWith("twitter.list.failed", catalog.Params{"error": err.Error()}))
+		return
+	}
+	names := make(map[string]string)
+	for _, user := range users {
+		names[user.Id_Str] = user.Screen_Name
+	}
+
+	//Names change; keep up with them
+	changed := false
+	usernames := make([]string, 0, len(ids))
+	b.lock.Lock()
+	for _, followed := range b.Config.Follow {
+		if name := names[followed.Id]; name != "" && name != followed.Screen_Name {
+			followed.Screen_Name = name
+			changed = true
+		}
+		//Whoever isn't there any more, we still follow by id
+		if followed.Screen_Name != "" {
+			usernames = append(usernames, "@"+followed.Screen_Name)
+		} else {
+			usernames = append(usernames, followed.Id)
+		}
+	}
+	b.lock.Unlock()
+	if changed {
+		b.SaveConfig()
+	}
+	for _, line := range pageList(usernames, maxListLine) {
+		r.reply(line)
+	}
+}
@@ -0,0 +1,252 @@
+package twitterbot
+
+import (
+	"../catalog"
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHistoryFile = "tweets.json"
+	//How long relayed items are remembered, and how many at most
+	historyAge  = 30 * 24 * time.Hour
+	historySize = 2000
+	//What !tweets replays when not asked for a number, and at most
+	defaultReplay = 5
+	maxReplay     = 20
+)
+
+//Read the items we relayed before. The file has one item in JSON per line,
+//so relaying one more is just appending.
+func (b *TwitterBot) LoadHistory() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	file, err := os.Open(b.Config.HistoryFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("twb: Can't read history,", err)
+		}
+		return
+	}
+	defer file.Close()
+
+	b.History = nil
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var item Item
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			log.Println("twb: Skipping broken line in history,", err)
+			continue
+		}
+		b.History = append(b.History, &item)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println("twb: Can't read history,", err)
+	}
+	log.Printf("twb: Remembered %d items\n", len(b.History))
+}
+
+//Add an item to the history, and to the file if there is one
+func (b *TwitterBot) remember(item *Item) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.History = append(b.History, item)
+	if b.Config.HistoryFile == "" {
+		return
+	}
+	line, err := json.Marshal(item)
+	if err != nil {
+		log.Println("twb: Can't save item,", err)
+		return
+	}
+	file, err := os.OpenFile(b.Config.HistoryFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Println("twb: Can't save item,", err)
+		return
+	}
+	defer file.Close()
+	file.Write(append(line, '\n'))
+}
+
+//Write the whole history to the file. Call with the lock held.
+func (b *TwitterBot) saveHistory() {
+	if b.Config.HistoryFile == "" {
+		return
+	}
+	//Write elsewhere first, so a crash doesn't cost us everything
+	temp := b.Config.HistoryFile + ".new"
+	file, err := os.Create(temp)
+	if err != nil {
+		log.Println("twb: Can't save history,", err)
+		return
+	}
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, item := range b.History {
+		encoder.Encode(item)
+	}
+	err = w.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp, b.Config.HistoryFile)
+	}
+	if err != nil {
+		log.Println("twb: Can't save history,", err)
+	}
+}
+
+//Forget what is too old, or too much
+func (b *TwitterBot) CleanHistory() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	oldlen := len(b.History)
+	limit := time.Now().Add(-historyAge)
+	start := 0
+	for start < oldlen && b.History[start].Time.Before(limit) {
+		start++
+	}
+	if oldlen-start > historySize {
+		start = oldlen - historySize
+	}
+	if start == 0 {
+		log.Printf("twb: No need to clean history, %d elements remain\n", oldlen)
+		return
+	}
+	b.History = append([]*Item(nil), b.History[start:]...)
+	b.saveHistory()
+
+	log.Printf("twb: Cleaned history, removed %d elements, %d remain\n",
+		oldlen-len(b.History), len(b.History))
+}
+
+//Forget an item that was deleted. If we relayed it, say so.
+func (b *TwitterBot) retract(deleted *Item) {
+	b.lock.Lock()
+	var retracted *Item
+	history := make([]*Item, 0, len(b.History))
+	for _, item := range b.History {
+		if item.Id == deleted.Id && (deleted.User.Id_Str == "" || item.User.Id_Str == deleted.User.Id_Str) {
+			retracted = item
+			continue
+		}
+		history = append(history, item)
+	}
+	b.History = history
+	if retracted != nil {
+		b.saveHistory()
+	}
+	b.lock.Unlock()
+
+	if retracted != nil {
+		followed, _ := b.followed(retracted.User.Id_Str)
+		b.say(followed.Channels, b.textWith("twitter.deleted", catalog.Params{"user": retracted.User.Screen_Name}))
+	}
+}
+
+//Split "erik 3" into "erik" and 3. Without a number, n is 1. A number on its
+//own is something to look for, as in "2024", not a count of nothing.
+func splitCount(query string) (string, int) {
+	words := strings.Fields(query)
+	if len(words) > 1 {
+		if n, err := strconv.Atoi(words[len(words)-1]); err == nil && n > 0 {
+			return strings.Join(words[:len(words)-1], " "), n
+		}
+	}
+	return strings.Join(words, " "), 1
+}
+
+//The items by the user whose name matches best: exactly, or else partly
+func byUser(history []*Item, name string) []*Item {
+	var exact, partial []*Item
+	name = strings.ToLower(name)
+	for _, item := range history {
+		screenName := strings.ToLower(item.User.Screen_Name)
+		if screenName == name {
+			exact = append(exact, item)
+		}
+		if strings.Contains(screenName, name) {
+			partial = append(partial, item)
+		}
+	}
+	if len(exact) > 0 {
+		return exact
+	}
+	return partial
+}
+
+func byText(history []*Item, text string) []*Item {
+	var found []*Item
+	text = strings.ToLower(text)
+	for _, item := range history {
+		if strings.Contains(strings.ToLower(format(item)), text) {
+			found = append(found, item)
+		}
+	}
+	return found
+}
+
+//Link to the last item, or the nth-last one of a user, or else the last one
+//with the query in it
+func (b *TwitterBot) OutputLink(r Request) {
+	b.lock.Lock()
+	history := b.History
+	b.lock.Unlock()
+	if len(history) == 0 {
+		r.reply(b.textWith("twitter.link.help", catalog.Params{"prefix": r.prefix()}))
+		return
+	}
+	if r.Arg == "ineke" {
+		r.reply(b.text("twitter.link.ineke"))
+		return
+	}
+
+	query, n := splitCount(r.Arg)
+	found := history
+	if query != "" {
+		if found = byUser(history, query); len(found) == 0 {
+			found = byText(history, query)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		r.reply(b.text("twitter.link.unknown"))
+	case len(found) < n:
+		r.reply(b.text("twitter.link.few"))
+	default:
+		r.reply(b.feed().Permalink(found[len(found)-n]))
+	}
+}
+
+//Say the last few items of a user again, oldest first
+func (b *TwitterBot) OutputTweets(r Request) {
+	b.lock.Lock()
+	history := b.History
+	b.lock.Unlock()
+
+	found := byUser(history, r.Arg)
+	if len(found) == 0 {
+		r.reply(b.text("twitter.link.unknown"))
+		return
+	}
+	n := r.Count
+	if n <= 0 {
+		n = defaultReplay
+	}
+	if n > maxReplay {
+		n = maxReplay
+	}
+	if n < len(found) {
+		found = found[len(found)-n:]
+	}
+	for _, item := range found {
+		r.reply(item.Time.Format("2006-01-02 15:04") + " " + format(item))
+	}
+}
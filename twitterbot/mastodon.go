@@ -0,0 +1,269 @@
+package twitterbot
+
+import (
+	"bufio"
+	"bytes"
+	"code.google.com/p/go.net/html"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type MastodonConfig struct {
+	//Where the bot's account lives, like https://mastodon.social
+	Server string
+	//Access token of the bot's account, from Preferences > Development
+	Token string
+	//Relay either a list of the bot's account, whose members are the
+	//followed accounts, or everything with a hashtag (without the #). With a
+	//hashtag, only followed accounts are relayed, unless nobody is followed.
+	List    string
+	Hashtag string
+}
+
+type mastodonAccount struct {
+	Id           string
+	Acct         string
+	Display_Name string
+}
+
+type mastodonStatus struct {
+	Id                     string
+	Content                string
+	Account                mastodonAccount
+	In_Reply_To_Account_Id string
+	//A boost has the boosted status in here, and no content of its own
+	Reblog *mastodonStatus
+}
+
+//Relays from the streaming API of a Mastodon server
+type mastodonSource struct {
+	conf MastodonConfig
+}
+
+func newMastodonSource(conf MastodonConfig) *mastodonSource {
+	conf.Server = strings.TrimRight(conf.Server, "/")
+	return &mastodonSource{conf: conf}
+}
+
+func (a mastodonAccount) user() User {
+	return User{Name: a.Display_Name, Screen_Name: a.Acct, Id_Str: a.Id}
+}
+
+//Do a request to the API and parse the JSON that comes back into result,
+//unless that is nil
+func (s *mastodonSource) call(method, path string, params url.Values, result interface{}) error {
+	u := s.conf.Server + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	response, err := s.do(req, restClient)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+func (s *mastodonSource) do(req *http.Request, client *http.Client) (*http.Response, error) {
+	if s.conf.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.conf.Token)
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, &StatusError{response.StatusCode,
+			fmt.Sprintf("%s %s: %s", req.Method, req.URL.Path, response.Status)}
+	}
+	return response, nil
+}
+
+func (s *mastodonSource) Connect(follow []string) (ItemStream, error) {
+	var u string
+	switch {
+	case s.conf.List != "":
+		u = s.conf.Server + "/api/v1/streaming/list?" + url.Values{"list": {s.conf.List}}.Encode()
+	case s.conf.Hashtag != "":
+		u = s.conf.Server + "/api/v1/streaming/hashtag?" + url.Values{"tag": {s.conf.Hashtag}}.Encode()
+	default:
+		return nil, fmt.Errorf("mastodon needs a list or a hashtag")
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.do(req, streamClient)
+	if err != nil {
+		return nil, err
+	}
+	stream := &mastodonStream{body: response.Body, input: bufio.NewReader(response.Body)}
+	if s.conf.List == "" && len(follow) > 0 {
+		stream.only = make(map[string]bool)
+		for _, id := range follow {
+			stream.only[id] = true
+		}
+	}
+	return stream, nil
+}
+
+//Accounts must be followed by the bot before they can go on its list
+func (s *mastodonSource) Follow(user User) error {
+	if s.conf.List == "" {
+		return nil
+	}
+	if err := s.call("POST", "/api/v1/accounts/"+url.PathEscape(user.Id_Str)+"/follow", nil, nil); err != nil {
+		return err
+	}
+	return s.call("POST", "/api/v1/lists/"+url.PathEscape(s.conf.List)+"/accounts",
+		url.Values{"account_ids[]": {user.Id_Str}}, nil)
+}
+
+func (s *mastodonSource) Unfollow(user User) error {
+	if s.conf.List == "" {
+		return nil
+	}
+	return s.call("DELETE", "/api/v1/lists/"+url.PathEscape(s.conf.List)+"/accounts",
+		url.Values{"account_ids[]": {user.Id_Str}}, nil)
+}
+
+func (s *mastodonSource) LookupUser(name string) (User, error) {
+	var account mastodonAccount
+	err := s.call("GET", "/api/v1/accounts/lookup",
+		url.Values{"acct": {strings.TrimPrefix(name, "@")}}, &account)
+	return account.user(), err
+}
+
+func (s *mastodonSource) LookupUsers(ids []string) ([]User, error) {
+	users := make([]User, 0, len(ids))
+	for _, id := range ids {
+		var account mastodonAccount
+		if err := s.call("GET", "/api/v1/accounts/"+url.PathEscape(id), nil, &account); err != nil {
+			return nil, err
+		}
+		users = append(users, account.user())
+	}
+	return users, nil
+}
+
+func (s *mastodonSource) Post(text string) (*Item, error) {
+	var status mastodonStatus
+	if err := s.call("POST", "/api/v1/statuses", url.Values{"status": {text}}, &status); err != nil {
+		return nil, err
+	}
+	return status.item(), nil
+}
+
+func (s *mastodonSource) Delete(item *Item) error {
+	return s.call("DELETE", "/api/v1/statuses/"+url.PathEscape(item.Id), nil, nil)
+}
+
+//The default of Mastodon; servers may allow more, but we don't ask
+func (s *mastodonSource) MaxLength() int { return 500 }
+
+func (s *mastodonSource) Permalink(item *Item) string {
+	return s.conf.Server + "/@" + item.User.Screen_Name + "/" + item.Id
+}
+
+//The streaming API sends server-sent events: an "event:" line, one or more
+//"data:" lines and an empty line.
+type mastodonStream struct {
+	body  io.ReadCloser
+	input *bufio.Reader
+	//If not nil, only relay these account ids
+	only map[string]bool
+}
+
+func (s *mastodonStream) Next() (*Item, error) {
+	var event string
+	var data bytes.Buffer
+	for {
+		line, err := ReadInputLine(s.input)
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(line[6:])
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(line[5:], " "))
+		}
+	}
+	switch event {
+	case "update":
+	case "delete":
+		//The data is just the id of the toot
+		return &Item{Id: strings.TrimSpace(data.String()), Deleted: true}, nil
+	default:
+		return nil, nil
+	}
+
+	var status mastodonStatus
+	if err := json.Unmarshal(data.Bytes(), &status); err != nil {
+		log.Println("twb: Err parsing mastodon stream:", err)
+		return nil, nil
+	}
+	if s.only != nil && !s.only[status.Account.Id] {
+		return nil, nil
+	}
+	return status.item(), nil
+}
+
+func (s *mastodonStatus) item() *Item {
+	item := &Item{
+		Id:      s.Id,
+		User:    s.Account.user(),
+		Text:    htmlToText(s.Content),
+		ReplyTo: s.In_Reply_To_Account_Id,
+	}
+	if s.Reblog != nil {
+		item.Retweet = s.Reblog.item()
+		item.Text = item.Retweet.Text
+	}
+	return item
+}
+
+func (s *mastodonStream) Close() error {
+	return s.body.Close()
+}
+
+//Toots come as HTML; we want the text, with paragraphs and line breaks
+//turned into spaces
+func htmlToText(content string) string {
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+	var text bytes.Buffer
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			text.WriteString(n.Data)
+		case n.Type == html.ElementNode && (n.Data == "br" || n.Data == "p"):
+			text.WriteString(" ")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return strings.Join(strings.Fields(text.String()), " ")
+}
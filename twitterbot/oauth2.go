@@ -0,0 +1,287 @@
+package twitterbot
+
+//OAuth 2.0 for Twitter: a bearer token for the app itself, or one for a user
+//by way of PKCE. twittersetup gets them; the bot uses whatever twitter.json
+//has, and refreshes user tokens when they run out.
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//What a user token may do: read, tweet, and be refreshed
+const oauth2Scopes = "tweet.read tweet.write users.read offline.access"
+
+//A user token from the PKCE flow. It only lasts a few hours; the refresh
+//token gets a new one.
+type OAuth2Token struct {
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+//What the token endpoints answer
+type tokenResponse struct {
+	Token_Type    string
+	Access_Token  string
+	Refresh_Token string
+	Expires_In    int
+}
+
+func (t *tokenResponse) token() *OAuth2Token {
+	token := &OAuth2Token{AccessToken: t.Access_Token, RefreshToken: t.Refresh_Token}
+	if t.Expires_In > 0 {
+		token.Expiry = time.Now().Add(time.Duration(t.Expires_In) * time.Second)
+	}
+	return token
+}
+
+//Ask a token endpoint for a token. Clients with a secret say who they are
+//with basic auth.
+func requestToken(endpoint, clientId, clientSecret string, form url.Values) (*tokenResponse, error) {
+	request, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(clientId), url.QueryEscape(clientSecret))
+	}
+	response, err := restClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, &StatusError{response.StatusCode, fmt.Sprintf("POST %s: %s", endpoint, response.Status)}
+	}
+	var token tokenResponse
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("can't parse token, %s", err)
+	}
+	if token.Access_Token == "" {
+		return nil, errors.New("no token in the answer")
+	}
+	return &token, nil
+}
+
+//Get a bearer token for the app itself, with CnsKey and CnsSecret. It can
+//read what is public, but not post.
+func AppToken(conf *Config) (string, error) {
+	token, err := requestToken(conf.Twitter.withDefaults().Api+"/oauth2/token",
+		conf.CnsKey, conf.CnsSecret, url.Values{"grant_type": {"client_credentials"}})
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(token.Token_Type, "bearer") {
+		return "", fmt.Errorf("expected a bearer token, got %q", token.Token_Type)
+	}
+	return token.Access_Token, nil
+}
+
+//A PKCE login in progress. The user allows the app in a browser, which is
+//then sent to RedirectUri with a code to trade for a token.
+type PKCE struct {
+	Verifier    string
+	State       string
+	RedirectUri string
+}
+
+func NewPKCE(redirectUri string) (*PKCE, error) {
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	return &PKCE{Verifier: verifier, State: state, RedirectUri: redirectUri}, nil
+}
+
+func randomString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+//Where the user logs in and allows the app with ClientId
+func (p *PKCE) AuthorizeUrl(conf *Config) string {
+	challenge := sha256.Sum256([]byte(p.Verifier))
+	return conf.Twitter.withDefaults().Web + "/i/oauth2/authorize?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {conf.ClientId},
+		"redirect_uri":          {p.RedirectUri},
+		"scope":                 {oauth2Scopes},
+		"state":                 {p.State},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+}
+
+//Trade what the user came back with for a token. That may be the whole
+//address the browser was sent to, or only the code in it.
+func (p *PKCE) Exchange(conf *Config, answer string) (*OAuth2Token, error) {
+	code := strings.TrimSpace(answer)
+	if u, err := url.Parse(code); err == nil && u.Query().Get("code") != "" {
+		if u.Query().Get("state") != p.State {
+			return nil, errors.New("the state doesn't match, start over")
+		}
+		code = u.Query().Get("code")
+	}
+	token, err := requestToken(conf.Twitter.withDefaults().Api+"/2/oauth2/token",
+		conf.ClientId, conf.ClientSecret, url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {p.RedirectUri},
+			"code_verifier": {p.Verifier},
+			"client_id":     {conf.ClientId},
+		})
+	if err != nil {
+		return nil, err
+	}
+	return token.token(), nil
+}
+
+//Signs requests with a bearer token. User tokens are refreshed when they
+//run out; saveToken hears about the new one.
+type bearerAuth struct {
+	conf                   TwitterConfig
+	clientId, clientSecret string
+	saveToken              func(OAuth2Token)
+
+	lock  sync.Mutex
+	token OAuth2Token
+}
+
+func (a *bearerAuth) Do(client *http.Client, method, u string, params map[string]string) (*http.Response, error) {
+	a.lock.Lock()
+	token := a.token
+	a.lock.Unlock()
+	if !token.Expiry.IsZero() && time.Now().After(token.Expiry) {
+		token, _ = a.refresh(token)
+	}
+	response, err := a.send(client, method, u, params, token.AccessToken)
+	if needsSetup(err) {
+		if fresh, ok := a.refresh(token); ok {
+			return a.send(client, method, u, params, fresh.AccessToken)
+		}
+	}
+	return response, err
+}
+
+func (a *bearerAuth) send(client *http.Client, method, u string, params map[string]string, token string) (*http.Response, error) {
+	form := url.Values{}
+	for key, value := range params {
+		form.Set(key, value)
+	}
+	var request *http.Request
+	var err error
+	if method == "GET" {
+		request, err = http.NewRequest(method, u+"?"+form.Encode(), nil)
+	} else {
+		request, err = http.NewRequest(method, u, strings.NewReader(form.Encode()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if method != "GET" {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		response.Body.Close()
+		return nil, &StatusError{response.StatusCode, fmt.Sprintf("%s %s: %s", method, u, response.Status)}
+	}
+	return response, nil
+}
+
+//Get a new token for the old one, unless someone else just did. Tells
+//whether there is a new one.
+func (a *bearerAuth) refresh(old OAuth2Token) (OAuth2Token, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.token.AccessToken != old.AccessToken {
+		return a.token, true
+	}
+	if old.RefreshToken == "" {
+		return old, false
+	}
+	token, err := requestToken(a.conf.Api+"/2/oauth2/token", a.clientId, a.clientSecret, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {old.RefreshToken},
+		"client_id":     {a.clientId},
+	})
+	if err != nil {
+		log.Println("twb: Can't refresh token,", err)
+		return old, false
+	}
+	fresh := token.token()
+	if fresh.RefreshToken == "" {
+		fresh.RefreshToken = old.RefreshToken
+	}
+	a.token = *fresh
+	if a.saveToken != nil {
+		a.saveToken(a.token)
+	}
+	return a.token, true
+}
+
+//Check that the credentials in conf work. Tells whose they are; for a
+//token of the app itself, that's nobody.
+func VerifyCredentials(conf *Config) (User, error) {
+	twitter := conf.Twitter.withDefaults()
+	auth := newTwitterAuth(conf, nil)
+	var user User
+	switch {
+	case conf.AccessToken != nil:
+		response, err := auth.Do(restClient, "GET", twitter.Api+"/1.1/account/verify_credentials.json", map[string]string{})
+		if err != nil {
+			return User{}, err
+		}
+		defer response.Body.Close()
+		if err := json.NewDecoder(response.Body).Decode(&user); err != nil {
+			return User{}, fmt.Errorf("can't parse user, %s", err)
+		}
+	case conf.OAuth2 != nil:
+		response, err := auth.Do(restClient, "GET", twitter.Api+"/2/users/me", map[string]string{})
+		if err != nil {
+			return User{}, err
+		}
+		defer response.Body.Close()
+		var me struct {
+			Data struct {
+				Id       string
+				Name     string
+				Username string
+			}
+		}
+		if err := json.NewDecoder(response.Body).Decode(&me); err != nil {
+			return User{}, fmt.Errorf("can't parse user, %s", err)
+		}
+		user = User{Name: me.Data.Name, Screen_Name: me.Data.Username, Id_Str: me.Data.Id}
+	default:
+		response, err := auth.Do(restClient, "GET", twitter.Api+"/1.1/application/rate_limit_status.json", map[string]string{})
+		if err != nil {
+			return User{}, err
+		}
+		response.Body.Close()
+	}
+	return user, nil
+}
@@ -0,0 +1,112 @@
+package twitterbot
+
+import (
+	"../catalog"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+//A FeedSource that can also post, as the bot's own account
+type Poster interface {
+	Post(text string) (*Item, error)
+	Delete(item *Item) error
+	//How many characters a post may have
+	MaxLength() int
+}
+
+//The poster of the source, if it can post
+func (b *TwitterBot) poster() (Poster, bool) {
+	poster, ok := b.feed().(Poster)
+	return poster, ok
+}
+
+//What posting is called on the source: a tweet on Twitter, a toot on Mastodon
+func (b *TwitterBot) postCommand() string {
+	if _, ok := b.feed().(*mastodonSource); ok {
+		return "toot"
+	}
+	return "tweet"
+}
+
+//The poster of the source, if it can post and the request calls it by its
+//name. Whoever asked is told otherwise.
+func (b *TwitterBot) posterFor(r Request) (Poster, bool) {
+	poster, ok := b.poster()
+	if !ok {
+		r.reply(b.text("twitter.post.unsupported"))
+		return nil, false
+	}
+	if r.Name != "" && r.Name != b.postCommand() {
+		r.reply(b.textWith("twitter.post.wrongcommand", catalog.Params{"prefix": r.prefix(), "command": b.postCommand()}))
+		return nil, false
+	}
+	return poster, true
+}
+
+//Show what would be posted, so whoever asked can think it over
+func (b *TwitterBot) PreviewPost(r Request) {
+	poster, ok := b.posterFor(r)
+	if !ok {
+		return
+	}
+	text := strings.TrimSpace(r.Arg)
+	params := catalog.Params{"text": text, "length": utf8.RuneCountInString(text), "max": poster.MaxLength()}
+	if utf8.RuneCountInString(text) > poster.MaxLength() {
+		r.reply(b.textWith("twitter.post.toolong", params))
+		return
+	}
+	params["prefix"], params["command"] = r.prefix(), b.postCommand()
+	r.reply(b.textWith("twitter.post.confirm", params))
+}
+
+func (b *TwitterBot) Post(r Request) {
+	poster, ok := b.posterFor(r)
+	if !ok {
+		return
+	}
+	text := strings.TrimSpace(r.Arg)
+	if utf8.RuneCountInString(text) > poster.MaxLength() {
+		r.reply(b.textWith("twitter.post.toolong", catalog.Params{
+			"text": text, "length": utf8.RuneCountInString(text), "max": poster.MaxLength(),
+		}))
+		return
+	}
+	item, err := poster.Post(text)
+	if err != nil {
+		log.Println("twb: Can't post,", err)
+		r.reply(b.textWith("twitter.post.failed", catalog.Params{"error": err.Error()}))
+		return
+	}
+	b.lock.Lock()
+	b.posted = append(b.posted, item)
+	b.lock.Unlock()
+	r.reply(b.textWith("twitter.post.done", catalog.Params{"link": b.feed().Permalink(item)}))
+}
+
+//Take back the last thing we posted. Asking again takes back the one before.
+func (b *TwitterBot) DeletePost(r Request) {
+	poster, ok := b.poster()
+	if !ok {
+		r.reply(b.text("twitter.post.unsupported"))
+		return
+	}
+	b.lock.Lock()
+	if len(b.posted) == 0 {
+		b.lock.Unlock()
+		r.reply(b.text("twitter.post.nothing"))
+		return
+	}
+	item := b.posted[len(b.posted)-1]
+	b.lock.Unlock()
+
+	if err := poster.Delete(item); err != nil {
+		log.Println("twb: Can't delete post,", err)
+		r.reply(b.textWith("twitter.post.failed", catalog.Params{"error": err.Error()}))
+		return
+	}
+	b.lock.Lock()
+	b.posted = b.posted[:len(b.posted)-1]
+	b.lock.Unlock()
+	r.reply(b.text("twitter.post.deleted"))
+}
@@ -1,71 +1,157 @@
 package main
 
+//Gets the twitterbot into Twitter, and writes how into its config. There are
+//three ways in:
+//	pin   OAuth 1.0a: log in as the bot's account and type over a PIN
+//	app   OAuth 2.0 app-only: a bearer token for the app, which can only read
+//	pkce  OAuth 2.0 with PKCE: log in as the bot's account in a browser and
+//	      paste the address it ends up at
+//Whatever comes out is tried before it is saved.
+
 import (
+	"../../twitterbot"
+	"bufio"
 	"encoding/json"
-	"io/ioutil"
+	"flag"
 	"fmt"
 	"github.com/mrjones/oauth"
+	"io/ioutil"
+	"os"
+	"strings"
 )
 
-type Config struct {
-	CnsKey, CnsSecret, Follow string
-	AccessToken *oauth.AccessToken
-}
-
 func main() {
-	jsonBlob, ioErr := ioutil.ReadFile("../../twitter.json")
+	var path, flow, redirect, clientId, clientSecret string
+	flag.StringVar(&path, "config", "../../twitter.json", "The twitterbot's config file")
+	flag.StringVar(&flow, "flow", "pin", "How to get in: pin, app or pkce")
+	flag.StringVar(&redirect, "redirect", "http://127.0.0.1/callback", "Where Twitter sends the browser after pkce; must be registered with the app")
+	flag.StringVar(&clientId, "client", "", "OAuth 2.0 client id, for pkce (ClientId in the config)")
+	flag.StringVar(&clientSecret, "secret", "", "OAuth 2.0 client secret, for pkce with a confidential client (ClientSecret in the config)")
+	flag.Parse()
+
+	jsonBlob, ioErr := ioutil.ReadFile(path)
 	if ioErr != nil {
-		fmt.Printf("Error opening file %s: %s\n", "../../twitter.json", ioErr)
-		return
+		fmt.Printf("Error opening file %s: %s\n", path, ioErr)
+		os.Exit(1)
 	}
 
-	var cfg Config
+	//The bot's own config, so whatever else is in the file stays there
+	var cfg twitterbot.Config
 	jsonErr := json.Unmarshal(jsonBlob, &cfg)
 	if jsonErr != nil {
-		fmt.Printf("Error parsing file %s: %s\n", "../../twitter.json", jsonErr)
-		return
+		fmt.Printf("Error parsing file %s: %s\n", path, jsonErr)
+		os.Exit(1)
+	}
+	if clientId != "" {
+		cfg.ClientId = clientId
+	}
+	if clientSecret != "" {
+		cfg.ClientSecret = clientSecret
+	}
+
+	var err error
+	switch flow {
+	case "pin":
+		err = pinFlow(&cfg)
+	case "app":
+		err = appFlow(&cfg)
+	case "pkce":
+		err = pkceFlow(&cfg, redirect)
+	default:
+		err = fmt.Errorf("unknown flow %q, use pin, app or pkce", flow)
 	}
-	
+	if err != nil {
+		fmt.Println("twb:", err)
+		os.Exit(1)
+	}
+
+	//Don't save what doesn't work
+	user, err := twitterbot.VerifyCredentials(&cfg)
+	if err != nil {
+		fmt.Println("twb: Twitter doesn't take the new credentials,", err)
+		os.Exit(1)
+	}
+	if user.Screen_Name != "" {
+		fmt.Printf("twb: Logged in as @%s\n", user.Screen_Name)
+	} else {
+		fmt.Println("twb: Logged in as the app; reading only")
+	}
+
+	jsonBlob, jsonErr = json.Marshal(cfg)
+	if jsonErr != nil {
+		fmt.Printf("Error converting to json: %s\n", jsonErr)
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(path, jsonBlob, 0600)
+	if err != nil {
+		fmt.Printf("Error saving file, %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("twb: Saved. If the bot is running, say !fixtwitter.")
+}
+
+//Read what the user types. Empty if there is nothing.
+func ask() string {
+	input := bufio.NewScanner(os.Stdin)
+	input.Scan()
+	return strings.TrimSpace(input.Text())
+}
+
+//OAuth 1.0a, out of band: the user types over a PIN
+func pinFlow(cfg *twitterbot.Config) error {
 	//prepare oAuth data
-	c := oauth.NewConsumer(
-		cfg.CnsKey,
-		cfg.CnsSecret,
-		oauth.ServiceProvider{
-			RequestTokenUrl:   "http://api.twitter.com/oauth/request_token",
-			AuthorizeTokenUrl: "https://api.twitter.com/oauth/authorize",
-			AccessTokenUrl:    "https://api.twitter.com/oauth/access_token",
-		})
+	c := oauth.NewConsumer(cfg.CnsKey, cfg.CnsSecret, cfg.Twitter.ServiceProvider())
 	//open twitter connection
 	requestToken, url, err := c.GetRequestTokenAndUrl("oob")
 	if err != nil {
-		fmt.Println("twb: An error occurred when requesting the token,", err)
-		return
+		return fmt.Errorf("an error occurred when requesting the token, %s", err)
 	}
 	//authenticate, make request
 	fmt.Println("twb: (1) Go to: " + url)
 	fmt.Println("twb: (2) Grant access, you should get back a verification code.")
 	fmt.Println("twb: (3) Enter that verification code here: ")
-	verificationCode := ""
-	fmt.Scanln(&verificationCode)
+	verificationCode := ask()
 
 	accessToken, err := c.AuthorizeToken(requestToken, verificationCode)
 	if err != nil {
-		fmt.Println("twb: An error occurred while validating your code,", err)
-		return
+		return fmt.Errorf("an error occurred while validating your code, %s", err)
 	}
-	
 	cfg.AccessToken = accessToken
+	cfg.OAuth2 = nil
+	cfg.BearerToken = ""
+	return nil
+}
 
-	//cfg is now ready to be used for requests
-	//save it to a file
-	
-	jsonBlob, jsonErr = json.Marshal(cfg)
-	if jsonErr != nil {
-		fmt.Printf("Error converting to json: %s\n", jsonErr)
-		return
+//OAuth 2.0 app-only: the consumer key and secret are enough
+func appFlow(cfg *twitterbot.Config) error {
+	token, err := twitterbot.AppToken(cfg)
+	if err != nil {
+		return fmt.Errorf("an error occurred when requesting the token, %s", err)
 	}
-	err = ioutil.WriteFile("../../twitter.json", jsonBlob, 0600)
+	cfg.AccessToken = nil
+	cfg.OAuth2 = nil
+	cfg.BearerToken = token
+	return nil
+}
+
+//OAuth 2.0 with PKCE: the user allows the app in a browser
+func pkceFlow(cfg *twitterbot.Config, redirect string) error {
+	if cfg.ClientId == "" {
+		return fmt.Errorf("pkce needs the app's OAuth 2.0 client id; give it with -client")
+	}
+	login, err := twitterbot.NewPKCE(redirect)
 	if err != nil {
-		fmt.Printf("Error saving file, %s\n", err)
+		return err
+	}
+	fmt.Println("twb: (1) Go to: " + login.AuthorizeUrl(cfg))
+	fmt.Println("twb: (2) Grant access. Your browser goes to " + redirect + ", which may well not load.")
+	fmt.Println("twb: (3) Enter the address it went to here: ")
+	token, err := login.Exchange(cfg, ask())
+	if err != nil {
+		return fmt.Errorf("an error occurred while validating your code, %s", err)
 	}
+	cfg.AccessToken = nil
+	cfg.OAuth2 = token
+	cfg.BearerToken = ""
+	return nil
 }
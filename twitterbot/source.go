@@ -0,0 +1,93 @@
+package twitterbot
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//Calls to the APIs of the networks give up after a while, so a server that
+//hangs can't hold up the requests from the channel. Streams stay open for as
+//long as they go, so they get a client without a timeout.
+const restTimeout = 30 * time.Second
+
+var (
+	restClient   = &http.Client{Timeout: restTimeout}
+	streamClient = &http.Client{}
+)
+
+//An Item is something posted on a feed: a tweet, a toot, a post. Or the news
+//that one was deleted, in which case there may be nothing but the Id.
+type Item struct {
+	Id      string
+	User    User
+	Text    string
+	Deleted bool
+	//The id of the user this is a reply to, if it is one
+	ReplyTo string
+	//What this shares or quotes, if anything. A retweet has no text of its
+	//own that's worth reading, the original has it all.
+	Retweet *Item
+	Quote   *Item
+	//When we relayed it
+	Time time.Time
+}
+
+//A FeedSource is a social network the bot can relay from. The bot keeps the
+//list of accounts to follow itself, as ids the source understands.
+type FeedSource interface {
+	//Open a stream of items by the followed accounts
+	Connect(follow []string) (ItemStream, error)
+	//Tell the network about a change to the followed accounts, for networks
+	//that keep the list on their side. Others need only a reconnect.
+	Follow(user User) error
+	Unfollow(user User) error
+	LookupUser(name string) (User, error)
+	LookupUsers(ids []string) ([]User, error)
+	//A link to the item on the web
+	Permalink(item *Item) string
+}
+
+type ItemStream interface {
+	//Wait for the next item. Some messages on a stream are not items; for
+	//those, Next returns nil without an error. Once Next has returned an
+	//error, the stream is broken and should be closed.
+	Next() (*Item, error)
+	Close() error
+}
+
+//Make the source that the configuration asks for. saveToken hears about
+//tokens that were refreshed.
+func newSource(conf *Config, saveToken func(OAuth2Token)) (FeedSource, error) {
+	switch conf.Source {
+	case "", "twitter":
+		return newTwitterSource(conf, saveToken), nil
+	case "mastodon":
+		return newMastodonSource(conf.Mastodon), nil
+	case "bluesky":
+		return newBlueskySource(conf.Bluesky), nil
+	}
+	return nil, fmt.Errorf("unknown source %q", conf.Source)
+}
+
+//The other end answered, but not with what we asked for
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return e.Status
+}
+
+//Whether the other end won't have our credentials any more, which only
+//setting things up again will fix
+func needsSetup(err error) bool {
+	switch e := err.(type) {
+	case *StatusError:
+		return e.Code == http.StatusUnauthorized
+	case *DisconnectError:
+		return e.Code == disconnectTokenRevoked
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package main
+
+//Runs a fake Twitter, to try the twitterbot without the real one. Every line
+//typed as "name: text" is tweeted by name; "-id" deletes a tweet.
+
+import (
+	"../faketwitter"
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func main() {
+	var listen, key, token, client, users string
+	flag.StringVar(&listen, "listen", "localhost:8081", "Address to listen on")
+	flag.StringVar(&key, "key", "key", "Consumer key the bot must use (CnsKey)")
+	flag.StringVar(&token, "token", "token", "Access token the bot must use")
+	flag.StringVar(&client, "client", "client", "OAuth 2.0 client id for twittersetup -flow pkce")
+	flag.StringVar(&users, "users", "", "Users to know from the start, as name=id,name=id")
+	flag.Parse()
+
+	server := faketwitter.New(key, token)
+	server.ClientId = client
+	for _, user := range strings.Split(users, ",") {
+		if parts := strings.SplitN(user, "=", 2); len(parts) == 2 {
+			server.AddUser(parts[0], parts[1])
+		}
+	}
+	go func() {
+		log.Fatalln(http.ListenAndServe(listen, server))
+	}()
+
+	fmt.Printf("Put this in twitter.json:\n"+
+		"\t\"CnsKey\":%q, \"AccessToken\":{\"Token\":%q, \"Secret\":\"secret\"},\n"+
+		"\t\"Twitter\":{\"Stream\":\"http://%s\", \"Api\":\"http://%s\", \"Web\":\"http://%s\"}\n"+
+		"or leave out AccessToken and run twittersetup -flow app, or -flow pkce -client %s\n",
+		key, token, listen, listen, listen, client)
+	input := bufio.NewScanner(os.Stdin)
+	for input.Scan() {
+		line := strings.TrimSpace(input.Text())
+		if strings.HasPrefix(line, "-") {
+			if !server.Delete(line[1:]) {
+				fmt.Println("No such tweet")
+			}
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			fmt.Println("Type name: text, or -id to delete")
+			continue
+		}
+		fmt.Println("Tweeted", server.Tweet(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])))
+	}
+}
@@ -0,0 +1,396 @@
+package twitterbot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/mrjones/oauth"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type User struct {
+	Name        string
+	Screen_Name string
+	Id_Str      string
+}
+type Tweet struct {
+	User     User
+	Id_Str   string
+	Text     string `json:"text"`
+	Entities tweetEntities
+	//Tweets longer than 140 characters are cut short in Text
+	Extended_Tweet *struct {
+		Full_Text string
+		Entities  tweetEntities
+	}
+	In_Reply_To_User_Id_Str string
+	Retweeted_Status        *Tweet
+	Quoted_Status           *Tweet
+}
+
+type tweetEntities struct {
+	Urls  []urlEntity
+	Media []urlEntity
+}
+
+//A t.co link in a tweet, and where it leads
+type urlEntity struct {
+	Url          string
+	Expanded_Url string
+}
+
+//Every line on the stream is one of these. Anything that isn't a tweet has
+//exactly one of the other fields.
+type streamMessage struct {
+	Tweet
+	Delete *struct {
+		Status struct {
+			Id_Str      string
+			User_Id_Str string
+		}
+	}
+	Limit *struct {
+		Track int
+	}
+	Warning *struct {
+		Code         string
+		Message      string
+		Percent_Full int
+	}
+	Disconnect *DisconnectError
+}
+
+//Twitter closes the stream, and tells us why. For some reasons, connecting
+//again won't help.
+type DisconnectError struct {
+	Code        int
+	Stream_Name string
+	Reason      string
+}
+
+const (
+	disconnectDuplicate    = 2
+	disconnectTokenRevoked = 6
+	disconnectAdminLogout  = 7
+)
+
+func (e *DisconnectError) Error() string {
+	return fmt.Sprintf("disconnected (%d): %s", e.Code, e.Reason)
+}
+
+//Whether we should stop trying until someone has fixed things
+func (e *DisconnectError) Final() bool {
+	return e.Code == disconnectTokenRevoked || e.Code == disconnectAdminLogout
+}
+
+//The text of the tweet with its t.co links replaced by where they lead
+func (t *Tweet) expandedText() string {
+	text, entities := t.Text, t.Entities
+	if t.Extended_Tweet != nil && t.Extended_Tweet.Full_Text != "" {
+		text, entities = t.Extended_Tweet.Full_Text, t.Extended_Tweet.Entities
+	}
+	for _, entity := range append(entities.Urls, entities.Media...) {
+		if entity.Url != "" && entity.Expanded_Url != "" {
+			text = strings.Replace(text, entity.Url, entity.Expanded_Url, -1)
+		}
+	}
+	return text
+}
+
+func (t *Tweet) item() *Item {
+	item := &Item{
+		Id:      t.Id_Str,
+		User:    t.User,
+		Text:    t.expandedText(),
+		ReplyTo: t.In_Reply_To_User_Id_Str,
+	}
+	if t.Retweeted_Status != nil {
+		//Text has "RT @someone: " in front, and may be cut short
+		item.Retweet = t.Retweeted_Status.item()
+		item.Text = item.Retweet.Text
+	}
+	if t.Quoted_Status != nil {
+		item.Quote = t.Quoted_Status.item()
+	}
+	return item
+}
+
+//Where to find Twitter. Anything left empty is Twitter's own; point them
+//elsewhere to run against a stub, like the one in faketwitter.
+type TwitterConfig struct {
+	//The streaming API
+	Stream string
+	//The REST API, which does OAuth too
+	Api string
+	//Where links to tweets go
+	Web string
+}
+
+const (
+	defaultTwitterStream = "https://stream.twitter.com"
+	defaultTwitterApi    = "https://api.twitter.com"
+	defaultTwitterWeb    = "https://twitter.com"
+	//users/lookup takes no more than this many at once
+	twitterLookupBatch = 100
+)
+
+func (c TwitterConfig) withDefaults() TwitterConfig {
+	if c.Stream == "" {
+		c.Stream = defaultTwitterStream
+	}
+	if c.Api == "" {
+		c.Api = defaultTwitterApi
+	}
+	if c.Web == "" {
+		c.Web = defaultTwitterWeb
+	}
+	c.Stream = strings.TrimRight(c.Stream, "/")
+	c.Api = strings.TrimRight(c.Api, "/")
+	c.Web = strings.TrimRight(c.Web, "/")
+	return c
+}
+
+//The OAuth endpoints, for getting an access token
+func (c TwitterConfig) ServiceProvider() oauth.ServiceProvider {
+	c = c.withDefaults()
+	return oauth.ServiceProvider{
+		RequestTokenUrl:   c.Api + "/oauth/request_token",
+		AuthorizeTokenUrl: c.Api + "/oauth/authorize",
+		AccessTokenUrl:    c.Api + "/oauth/access_token",
+	}
+}
+
+//Signs requests to the API, one way or another, and sends them with the
+//client: restClient, or streamClient for a stream. Answers other than 2xx come
+//back as a *StatusError.
+type twitterAuth interface {
+	Do(client *http.Client, method, url string, params map[string]string) (*http.Response, error)
+}
+
+//Picks how to sign in, from what twitter.json has: an OAuth 1.0a access
+//token, a user token from OAuth 2.0, or else a bearer token for the app
+func newTwitterAuth(conf *Config, saveToken func(OAuth2Token)) twitterAuth {
+	switch {
+	case conf.AccessToken != nil:
+		provider := conf.Twitter.ServiceProvider()
+		return &oauth1Auth{
+			rest:   oauth.NewCustomHttpClientConsumer(conf.CnsKey, conf.CnsSecret, provider, restClient),
+			stream: oauth.NewCustomHttpClientConsumer(conf.CnsKey, conf.CnsSecret, provider, streamClient),
+			token:  conf.AccessToken,
+		}
+	case conf.OAuth2 != nil:
+		return &bearerAuth{
+			conf:         conf.Twitter.withDefaults(),
+			clientId:     conf.ClientId,
+			clientSecret: conf.ClientSecret,
+			saveToken:    saveToken,
+			token:        *conf.OAuth2,
+		}
+	}
+	return &bearerAuth{token: OAuth2Token{AccessToken: conf.BearerToken}}
+}
+
+//A consumer comes with its own client, so there is one for each
+type oauth1Auth struct {
+	rest, stream *oauth.Consumer
+	token        *oauth.AccessToken
+}
+
+func (a *oauth1Auth) Do(client *http.Client, method, url string, params map[string]string) (*http.Response, error) {
+	consumer := a.rest
+	if client == streamClient {
+		consumer = a.stream
+	}
+	var response *http.Response
+	var err error
+	if method == "GET" {
+		response, err = consumer.Get(url, params, a.token)
+	} else {
+		response, err = consumer.Post(url, params, a.token)
+	}
+	if httpErr, ok := err.(oauth.HTTPExecuteError); ok {
+		return nil, &StatusError{httpErr.StatusCode, fmt.Sprintf("%s %s: %s", method, url, httpErr.Status)}
+	}
+	return response, err
+}
+
+//Relays from the Twitter streaming API
+type twitterSource struct {
+	conf TwitterConfig
+	auth twitterAuth
+}
+
+//saveToken hears about refreshed user tokens, and may be nil
+func newTwitterSource(conf *Config, saveToken func(OAuth2Token)) *twitterSource {
+	return &twitterSource{
+		conf: conf.Twitter.withDefaults(),
+		auth: newTwitterAuth(conf, saveToken),
+	}
+}
+
+func (s *twitterSource) Connect(follow []string) (ItemStream, error) {
+	//open stream for reading
+	response, err := s.auth.Do(streamClient, "POST",
+		s.conf.Stream+"/1.1/statuses/filter.json",
+		map[string]string{"follow": strings.Join(follow, ",")})
+	if err != nil {
+		return nil, err
+	}
+	return newTweetStream(response.Body), nil
+}
+
+//Twitter filters the stream by the follow parameter, so there is nothing to
+//tell it in advance
+func (s *twitterSource) Follow(user User) error   { return nil }
+func (s *twitterSource) Unfollow(user User) error { return nil }
+
+//Errors from the other end come back as they are, so the bot can tell when
+//it has to be set up again
+func (s *twitterSource) LookupUsers(ids []string) ([]User, error) {
+	users := make([]User, 0, len(ids))
+	for start := 0; start < len(ids); start += twitterLookupBatch {
+		end := start + twitterLookupBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		response, err := s.auth.Do(restClient, "POST",
+			s.conf.Api+"/1.1/users/lookup.json",
+			map[string]string{"user_id": strings.Join(ids[start:end], ",")})
+		if statusErr, ok := err.(*StatusError); ok && statusErr.Code == http.StatusNotFound {
+			//None of them exist any more
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var batch []User
+		err = json.NewDecoder(response.Body).Decode(&batch)
+		response.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("can't parse users, %s", err)
+		}
+		users = append(users, batch...)
+	}
+	return users, nil
+}
+
+func (s *twitterSource) LookupUser(name string) (User, error) {
+	response, err := s.auth.Do(restClient, "GET",
+		s.conf.Api+"/1.1/users/show.json",
+		map[string]string{"screen_name": name})
+	if err != nil {
+		return User{}, err
+	}
+	jsonBlob, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	var user User
+	if err := json.Unmarshal(jsonBlob, &user); err != nil {
+		return User{}, fmt.Errorf("can't parse user, %s", err)
+	}
+	return user, nil
+}
+
+func (s *twitterSource) Post(text string) (*Item, error) {
+	response, err := s.auth.Do(restClient, "POST",
+		s.conf.Api+"/1.1/statuses/update.json",
+		map[string]string{"status": text})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var tweet Tweet
+	if err := json.NewDecoder(response.Body).Decode(&tweet); err != nil {
+		return nil, fmt.Errorf("can't parse tweet, %s", err)
+	}
+	return tweet.item(), nil
+}
+
+func (s *twitterSource) Delete(item *Item) error {
+	response, err := s.auth.Do(restClient, "POST",
+		s.conf.Api+"/1.1/statuses/destroy/"+item.Id+".json",
+		map[string]string{})
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (s *twitterSource) MaxLength() int { return 280 }
+
+func (s *twitterSource) Permalink(item *Item) string {
+	return s.conf.Web + "/" + item.User.Screen_Name +
+		"/status/" + item.Id
+}
+
+//The stream has one tweet (or other message) in JSON per line
+type tweetStream struct {
+	body  io.ReadCloser
+	input *bufio.Reader
+}
+
+func newTweetStream(body io.ReadCloser) *tweetStream {
+	return &tweetStream{body: body, input: bufio.NewReader(body)}
+}
+
+func (s *tweetStream) Next() (*Item, error) {
+	line, err := ReadInputLine(s.input)
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, nil
+	}
+
+	//Parse the message
+	var message streamMessage
+	if err := json.Unmarshal([]byte(line), &message); err != nil {
+		log.Println("twb: Err parsing stream:", err)
+		return nil, nil
+	}
+	switch {
+	case message.Delete != nil:
+		return &Item{
+			Id:      message.Delete.Status.Id_Str,
+			User:    User{Id_Str: message.Delete.Status.User_Id_Str},
+			Deleted: true,
+		}, nil
+	case message.Limit != nil:
+		log.Printf("twb: Rate limited, missed %d tweets\n", message.Limit.Track)
+		return nil, nil
+	case message.Warning != nil:
+		log.Printf("twb: Stall warning %s (%d%% full): %s\n", message.Warning.Code,
+			message.Warning.Percent_Full, message.Warning.Message)
+		return nil, nil
+	case message.Disconnect != nil:
+		return nil, message.Disconnect
+	}
+
+	tweet := message.Tweet
+	if len(tweet.User.Screen_Name) == 0 || len(tweet.Text) == 0 {
+		return nil, nil
+	}
+	return tweet.item(), nil
+}
+
+func (s *tweetStream) Close() error {
+	return s.body.Close()
+}
+
+//Because of a nebulous "issue 1725" in http, ReadString doesn't return an error,
+//but instead panics if the connection is closed, even if from the other side.
+func ReadInputLine(input *bufio.Reader) (line string, err error) {
+	defer func() {
+		if pan := recover(); pan != nil {
+			line = ""
+			err = fmt.Errorf("%v", pan)
+		}
+	}()
+	return input.ReadString('\n')
+}
@@ -1,11 +1,10 @@
 package twitterbot
 
 import (
-	"bufio"
+	"../catalog"
 	"encoding/json"
 	"fmt"
 	"github.com/mrjones/oauth"
-	"io"
 	"io/ioutil"
 	"log"
 	"strings"
@@ -13,63 +12,144 @@ import (
 	"time"
 )
 
-type User struct {
-	Name        string
-	Screen_Name string
-	Id_Str      string
-}
-type Tweet struct {
-	User   User
-	Id_Str string
-	Text   string "text"
-}
-
+//The twitterbot runs in three goroutines: one reading the stream
+//(ReadContinuous), one handling requests (ListenControl) and one cleaning the
+//history once a day. Everything they share is guarded by lock. Only the
+//reading goroutine connects; others reset the connection by closing it.
 type TwitterBot struct {
-	Conn    *io.ReadCloser
-	Input   *bufio.Reader
-	Output  chan string
-	Control chan string
+	Output  chan Message
+	Control chan Request
 	Config  *Config
-	History []*Tweet
-	Once    *sync.Once
+	History []*Item
+	//Messages, in the language of the channel we talk to, and how commands
+	//are given there
+	Texts    *catalog.Catalog
+	Language string
+	Prefix   string
+
+	lock   sync.Mutex
+	source FeedSource
+	conn   ItemStream
+	status connStatus
+	//Waits between connection attempts, or until woken; replaced when testing
+	sleep func(time.Duration, <-chan bool)
+	//Wakes the reader after the stream was closed for good
+	wake chan bool
+	//What we posted ourselves, for !deltweet
+	posted []*Item
+	//What we know of users, by id
+	users map[string]cachedUser
+}
+
+//How the connection is doing, for !twitterstatus
+type connStatus struct {
+	Connected bool
+	Since     time.Time
+	Err       error
+	Failures  int
+	Retry     time.Time
+	//Not even trying, until someone resets the connection
+	Stopped bool
 }
 
 type Config struct {
-	CnsKey, CnsSecret, Follow string
-	AccessToken               *oauth.AccessToken
+	CnsKey, CnsSecret string
+	AccessToken       *oauth.AccessToken
+	Follow            FollowList
+	//Where to relay from: "twitter" (the default), "mastodon" or "bluesky".
+	//Follow holds accounts on that network.
+	Source   string
+	Twitter  TwitterConfig
+	Mastodon MastodonConfig
+	Bluesky  BlueskyConfig
+	//What to relay of each followed account, by id. Only read, from before
+	//filters moved into Follow.
+	Filters map[string]*Filter `json:",omitempty"`
+	//Where relayed items are kept, tweets.json if left empty
+	HistoryFile string
+	//Where the names of users are kept, users.json if left empty
+	UserFile string
+	//Instead of AccessToken: a user token from OAuth 2.0, for the app with
+	//ClientId, or else a bearer token for the app itself. twittersetup gets
+	//either.
+	ClientId     string       `json:",omitempty"`
+	ClientSecret string       `json:",omitempty"`
+	OAuth2       *OAuth2Token `json:",omitempty"`
+	BearerToken  string       `json:",omitempty"`
 }
 
+type Command string
+
 const (
-	CTL_REREAD_CONFIG = "reread"
-	CTL_RECONNECT     = "reconn"
-	CTL_ADD_USER      = "add"
-	CTL_DEL_USER      = "del"
-	CTL_LIST_USERS    = "list"
-	CTL_OUTPUT_LINK   = "link"
+	CTL_REREAD_CONFIG Command = "reread"
+	CTL_RECONNECT     Command = "reconn"
+	CTL_ADD_USER      Command = "add"
+	CTL_DEL_USER      Command = "del"
+	CTL_LIST_USERS    Command = "list"
+	CTL_OUTPUT_LINK   Command = "link"
+	CTL_STATUS        Command = "status"
+	CTL_FILTER        Command = "filter"
+	CTL_OUTPUT_TWEETS Command = "tweets"
+	CTL_PREVIEW_POST  Command = "preview"
+	CTL_POST          Command = "post"
+	CTL_DELETE_POST   Command = "delpost"
 )
 
+//A Request asks the twitterbot to do something. Whatever it has to say in
+//return is sent on Reply, which is closed when the request has been handled.
+//Reply may be nil if nobody is listening.
+type Request struct {
+	Command Command
+	Arg     string
+	//For CTL_FILTER; without it, the filter is only shown
+	Filter *FilterChange
+	//For CTL_OUTPUT_TWEETS, how many
+	Count int
+	//For CTL_ADD_USER, who asked, and where to relay
+	Sender   string
+	Channels []string
+	//How commands are given where the request came from, like "!" and
+	//"toot", for texts that tell what to say next
+	Prefix string
+	Name   string
+	Reply  chan<- string
+}
+
+//Something to say on IRC. Without a Channel, it goes to the main channel.
+type Message struct {
+	Channel string
+	Text    string
+}
+
+//The command prefix for texts, "!" if the request didn't say
+func (r Request) prefix() string {
+	if r.Prefix == "" {
+		return "!"
+	}
+	return r.Prefix
+}
+
+func (r Request) reply(text string) {
+	if r.Reply != nil {
+		r.Reply <- text
+	}
+}
+
 func main() {
-	b := CreateBot(make(chan string), make(chan string))
+	b := CreateBot(make(chan Message), make(chan Request), catalog.New(), catalog.DefaultLanguage, "!")
 	go b.ReadContinuous()
 	for {
-		fmt.Println(<-b.Output)
+		fmt.Println((<-b.Output).Text)
 	}
 }
 
-func CreateBot(OutputChannel, ControlChannel chan string) *TwitterBot {
-	b := &TwitterBot{
-		Conn:    nil,
-		Input:   nil,
-		Output:  OutputChannel,
-		Control: ControlChannel,
-		Config:  new(Config),
-		History: nil,
-		Once:    new(sync.Once),
-	}
+func CreateBot(OutputChannel chan Message, ControlChannel chan Request, texts *catalog.Catalog, language, prefix string) *TwitterBot {
+	b := newBot(OutputChannel, ControlChannel, texts, language, prefix)
 	if !b.ReadConfig() {
 		return nil
 	}
-	b.Connect()
+	b.LoadHistory()
+	b.LoadUsers()
 	go b.ListenControl()
 	go func() {
 		for {
@@ -80,50 +160,139 @@ func CreateBot(OutputChannel, ControlChannel chan string) *TwitterBot {
 	return b
 }
 
-func (b *TwitterBot) Connect() {
-	//Create a consumer and connect to Twitter
-	//prepare oAuth data
-	c := oauth.NewConsumer(
-		b.Config.CnsKey,
-		b.Config.CnsSecret,
-		oauth.ServiceProvider{
-			RequestTokenUrl:   "http://api.twitter.com/oauth/request_token",
-			AuthorizeTokenUrl: "https://api.twitter.com/oauth/authorize",
-			AccessTokenUrl:    "https://api.twitter.com/oauth/access_token",
-		})
-	//open stream for reading
-	response, err := c.Post(
-		"https://stream.twitter.com/1.1/statuses/filter.json",
-		map[string]string{"follow": b.Config.Follow}, b.Config.AccessToken)
+func newBot(OutputChannel chan Message, ControlChannel chan Request, texts *catalog.Catalog, language, prefix string) *TwitterBot {
+	return &TwitterBot{
+		Output:   OutputChannel,
+		Control:  ControlChannel,
+		Config:   new(Config),
+		History:  nil,
+		Texts:    texts,
+		Language: language,
+		Prefix:   prefix,
+		sleep:    sleepUnlessWoken,
+		wake:     make(chan bool, 1),
+	}
+}
+
+func (b *TwitterBot) feed() FeedSource {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.source
+}
+
+//Open the stream and make it the current connection
+func (b *TwitterBot) Connect() (ItemStream, error) {
+	stream, err := b.feed().Connect(b.following())
 	if err != nil {
-		b.Output <- "Walvissen vallen het schip aan!"
-		log.Fatalln("twb: An error occurred while accessing the stream,", err)
+		return nil, err
 	}
 
-	b.Conn = &response.Body
-	b.Input = bufio.NewReader(response.Body)
+	b.lock.Lock()
+	b.conn = stream
+	b.status = connStatus{Connected: true, Since: time.Now()}
+	b.lock.Unlock()
 	log.Println("twb: Connection established, listening...")
+	return stream, nil
 }
 
+//Keep relaying, whatever happens to the connection
 func (b *TwitterBot) ReadContinuous() {
+	var bo backoff
+	for {
+		stream, err := b.Connect()
+		if needsSetup(err) {
+			b.waitForReset(err)
+			bo.reset()
+			continue
+		}
+		if err != nil {
+			//The first time we can't get in, we tell the channel
+			if bo.failures == 0 {
+				b.Output <- Message{Text: b.text("twitter.failed")}
+			}
+			b.waitAfter(&bo, err)
+			continue
+		}
+
+		err = b.readStream(stream, &bo)
+		b.lock.Lock()
+		//If someone else closed it, they want us to reconnect right away
+		reset := b.conn != stream
+		b.lock.Unlock()
+		b.closeConnection()
+		if reset {
+			log.Println("twb: Connection reset, reconnecting")
+			continue
+		}
+		if disconnect, ok := err.(*DisconnectError); ok && disconnect.Final() {
+			b.waitForReset(err)
+			bo.reset()
+			continue
+		}
+		log.Println("twb: Err in stream, reconnecting:", err)
+		b.waitAfter(&bo, err)
+	}
+}
+
+//Connecting again won't help, so wait until someone has fixed things and
+//asks for a reset
+func (b *TwitterBot) waitForReset(err error) {
+	log.Println("twb: Stream closed for good, waiting for a reset:", err)
+	b.lock.Lock()
+	b.status = connStatus{Err: err, Stopped: true}
+	b.lock.Unlock()
+	//Forget resets from before
+	select {
+	case <-b.wake:
+	default:
+	}
+	if needsSetup(err) {
+		b.Output <- Message{Text: b.textWith("twitter.unauthorized", catalog.Params{"prefix": b.Prefix})}
+	} else {
+		b.Output <- Message{Text: b.text("twitter.failed")}
+	}
+	<-b.wake
+}
+
+//Wait as long as the backoff says
+func (b *TwitterBot) waitAfter(bo *backoff, err error) {
+	wait := bo.fail(err)
+	log.Printf("twb: Can't connect (attempt %d): %s. Waiting %s\n", bo.failures, err, wait)
+	b.lock.Lock()
+	b.status = connStatus{Err: err, Failures: bo.failures, Retry: time.Now().Add(wait)}
+	b.lock.Unlock()
+	b.sleep(wait, b.wake)
+}
+
+//Sleep, but not any longer once someone asks for a reset
+func sleepUnlessWoken(wait time.Duration, wake <-chan bool) {
+	select {
+	case <-wake:
+	case <-time.After(wait):
+	}
+}
+
+//Relay from the stream until it breaks. Once something comes in, the
+//connection counts as working again.
+func (b *TwitterBot) readStream(stream ItemStream, bo *backoff) error {
 	for {
 		//Read a tweet
-		line, err := b.ReadInputLine()
+		item, err := stream.Next()
 		if err != nil {
-			log.Println("twb: Err in stream, reconnecting:", err)
-			b.WantResetConnection()
+			return err
+		}
+		bo.reset()
+		if item == nil {
 			continue
 		}
-		line = strings.TrimSpace(line)
-		if line == "" {
+		if item.Deleted {
+			b.retract(item)
 			continue
 		}
 
-		//Parse tweet
-		var tweet Tweet
-		jErr := json.Unmarshal([]byte(line), &tweet)
-		if jErr != nil {
-			log.Println("twb: Err parsing stream:", jErr)
+		channels, ok := b.wanted(item)
+		if !ok {
+			continue
 		}
 
 		// Replace needless unicode and newlines
@@ -132,271 +301,187 @@ func (b *TwitterBot) ReadContinuous() {
 			"’", "'", "‘", "`",
 			"”", "\"", "“", "\"",
 			"…", "...")
-		tweet.Text = r.Replace(tweet.Text)
+		item.Text = r.Replace(item.Text)
+		if item.Quote != nil {
+			item.Quote.Text = r.Replace(item.Quote.Text)
+		}
 
 		//Print tweet to output channel
-		if len(tweet.User.Screen_Name) > 0 && len(tweet.Text) > 0 {
-			b.History = append(b.History, &tweet)
-			b.Output <- fmt.Sprintf("[@%s] %s", tweet.User.Screen_Name, tweet.Text)
+		if len(item.User.Screen_Name) > 0 && len(item.Text) > 0 {
+			item.Time = time.Now()
+			b.remember(item)
+			b.say(channels, format(item))
 		}
 	}
 }
 
-//Because of a nebulous "issue 1725" in http, ReadString doesn't return an error,
-//but instead panics if the connection is closed, even if from the other side.
-func (b *TwitterBot) ReadInputLine() (line string, err error) {
-	defer func() {
-		if pan := recover(); pan != nil {
-			line = ""
-			err = fmt.Errorf("%v", pan)
-		}
-	}()
-	return b.Input.ReadString('\n')
+//Say something in the channels, or the main channel if there are none
+func (b *TwitterBot) say(channels []string, text string) {
+	if len(channels) == 0 {
+		b.Output <- Message{Text: text}
+	}
+	for _, channel := range channels {
+		b.Output <- Message{Channel: channel, Text: text}
+	}
 }
 
-func (b *TwitterBot) ListenControl() {
-	for {
-		switch c := <-b.Control; c {
-		case CTL_ADD_USER:
-			if b.AddTwit(<-b.Control) {
-				b.WantResetConnection()
-			}
-		case CTL_DEL_USER:
-			if b.DelTwit(<-b.Control) {
-				b.WantResetConnection()
-			}
-		case CTL_LIST_USERS:
-			b.ListTwits()
-		case CTL_REREAD_CONFIG:
-			b.ReadConfig()
-			fallthrough
-		case CTL_RECONNECT:
-			b.WantResetConnection()
-		case CTL_OUTPUT_LINK:
-			b.OutputLink(<-b.Control)
-		default:
-			log.Printf("twb: Ignoring invalid control <%s>\n", c)
+func (b *TwitterBot) OutputStatus(r Request) {
+	b.lock.Lock()
+	status := b.status
+	b.lock.Unlock()
+	switch {
+	case status.Stopped:
+		r.reply(b.textWith("twitter.status.stopped", catalog.Params{"error": status.Err.Error(), "prefix": r.prefix()}))
+	case status.Connected:
+		r.reply(b.textWith("twitter.status.connected", catalog.Params{
+			"since": status.Since.Format("2006-01-02 15:04"),
+		}))
+	case status.Err != nil:
+		wait := status.Retry.Sub(time.Now())
+		if wait < 0 {
+			wait = 0
 		}
+		r.reply(b.textWith("twitter.status.waiting", catalog.Params{
+			"error":    status.Err.Error(),
+			"attempts": status.Failures,
+			"seconds":  int((wait + time.Second - 1) / time.Second),
+		}))
+	default:
+		r.reply(b.text("twitter.status.connecting"))
 	}
 }
-func (b *TwitterBot) CleanHistory() {
-	oldlen := len(b.History)
-	if oldlen < 2 {
-		log.Printf("twb: No need to clean history, %d elements remain\n", oldlen)
-		return
-	}
 
-	//Only save one tweet per username (the last one)
-	hmap := make(map[string]*Tweet)
-	for _, t := range b.History {
-		hmap[(*t).User.Screen_Name] = t
-	}
-	//...except for the actual last one in the slice, we'll add that later
-	delete(hmap, (*(b.History[oldlen-1])).User.Screen_Name)
-	//(Rewriting the loop instead causes two tweets by the last user to remain)
-
-	//Put the saved tweets in a new slice
-	hnew := make([]*Tweet, 0, len(hmap)+1)
-	for _, t := range hmap {
-		hnew = append(hnew, t)
+func (b *TwitterBot) ListenControl() {
+	for r := range b.Control {
+		b.HandleRequest(r)
+		if r.Reply != nil {
+			close(r.Reply)
+		}
 	}
-
-	//Give the bot the newly rewritten History
-	//If we had tweets, append the last one to guarantee that !link is
-	//correct without arguments. Duplicate tweet is not a problem,
-	//and will not multiply after subsequent calls to CleanHistory.
-	b.History = append(hnew, b.History[oldlen-1])
-
-	log.Printf("twb: Cleaned history, removed %d elements, %d remain\n",
-		oldlen-len(b.History), len(b.History))
 }
 
-func (b *TwitterBot) OutputLink(query string) {
-	if len(b.History) == 0 {
-		b.Output <- "Als ik tweets heb herhaald, kun je een link opvragen naar" +
-			" de laatste tweet met '!link', of een link naar de laatste tweet" +
-			" van bijvoorbeeld @ineke met '!link ineke'."
-		return
-	}
-	if query == "ineke" {
-		b.Output <- "Inderdaad. Jammer dat ze niet op Twitter zit hè.."
-		return
-	}
-	//By now, we know there are tweets in the history
-	for i := range b.History {
-		//We want to search the history in reverse: latest tweet gets linked
-		tweet := b.History[len(b.History)-i-1]
-		if strings.Contains((*tweet).User.Screen_Name, query) {
-			b.Output <- tweet.Link()
-			return
+func (b *TwitterBot) HandleRequest(r Request) {
+	switch r.Command {
+	case CTL_ADD_USER:
+		if b.AddTwit(r) {
+			b.WantResetConnection()
 		}
+	case CTL_DEL_USER:
+		if b.DelTwit(r) {
+			b.WantResetConnection()
+		}
+	case CTL_LIST_USERS:
+		b.ListTwits(r)
+	case CTL_REREAD_CONFIG:
+		b.ReadConfig()
+		b.WantResetConnection()
+	case CTL_RECONNECT:
+		b.WantResetConnection()
+	case CTL_OUTPUT_LINK:
+		b.OutputLink(r)
+	case CTL_OUTPUT_TWEETS:
+		b.OutputTweets(r)
+	case CTL_STATUS:
+		b.OutputStatus(r)
+	case CTL_FILTER:
+		b.SetFilter(r)
+	case CTL_PREVIEW_POST:
+		b.PreviewPost(r)
+	case CTL_POST:
+		b.Post(r)
+	case CTL_DELETE_POST:
+		b.DeletePost(r)
+	default:
+		log.Printf("twb: Ignoring invalid control <%s>\n", r.Command)
 	}
-	b.Output <- "Welnu, ik word misschien wat ouder, maar van die gebruiker" +
-		" heb ik nog nooit gehoord."
 }
-func (t *Tweet) Link() string {
-	return "https://twitter.com/" + (*t).User.Screen_Name +
-		"/status/" + (*t).Id_Str
+
+func (b *TwitterBot) text(key string) string {
+	return b.textWith(key, nil)
 }
 
+func (b *TwitterBot) textWith(key string, params catalog.Params) string {
+	return b.Texts.Text(b.Language, key, params)
+}
+
+//Make the reading goroutine reconnect, by closing the connection under its
+//feet. If it is busy connecting or waiting already, there is nothing to do,
+//unless it has given up.
 func (b *TwitterBot) WantResetConnection() {
-	// It's possible for multiple threads to reconnect at the same time.
-	// This makes sure only one of them actually does it.
-	defer func() {
-		if r := recover(); r != nil {
-			b.Output <- "Critical existence failure!"
-			log.Panicln(r)
-		}
-	}()
-	onceBody := func() {
-		b.ResetConnection()
+	b.closeConnection()
+	select {
+	case b.wake <- true:
+	default:
 	}
-	b.Once.Do(onceBody)
 }
 
-func (b *TwitterBot) ResetConnection() {
-	// Actual reconnection code
+func (b *TwitterBot) closeConnection() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
 	// Sometimes, the connection is already closed.
 	// Sometimes it's just broken somehow, and not closing it would be a leak.
-	if b.Conn != nil {
-		(*b.Conn).Close()
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
 	}
-	b.Connect()
-	b.Once = new(sync.Once)
 }
 
 func (b *TwitterBot) ReadConfig() bool {
-	//Re-read the configuration file used to start the bot
+	ok, migrated := b.readConfig()
+	if migrated {
+		log.Println("twb: Moved twitter.json to the new list of followed accounts")
+		b.SaveConfig()
+	}
+	return ok
+}
+
+//Re-read the configuration file used to start the bot. Tells whether that
+//worked, and whether it was in an old format.
+func (b *TwitterBot) readConfig() (ok bool, migrated bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
 	jsonBlob, ioErr := ioutil.ReadFile("twitter.json")
 	if ioErr != nil {
 		log.Printf("Error opening file %s: %s\n", "twitter.json", ioErr)
-		return false
+		return false, false
 	}
 
 	jsonErr := json.Unmarshal(jsonBlob, b.Config)
 	if jsonErr != nil {
 		log.Printf("Error parsing file %s: %s\n", "twitter.json", jsonErr)
-		return false
+		return false, false
 	}
-	return true
-}
-func (b *TwitterBot) SaveConfig() bool {
-	jsonBlob, jsonErr := json.Marshal(b.Config)
-	if jsonErr != nil {
-		log.Println("Error converting to JSON:", jsonErr)
-		return false
+	migrated = b.Config.migrate(jsonBlob)
+	if b.Config.HistoryFile == "" {
+		b.Config.HistoryFile = defaultHistoryFile
 	}
-	return ioutil.WriteFile("twitter.json", jsonBlob, 0644) == nil
-}
-
-func (b *TwitterBot) AddTwit(twit string) bool {
-	user, success := b.userFromName(twit)
-	if !success {
-		b.Output <- "Die persoon ken ik niet."
-		return false
+	if b.Config.UserFile == "" {
+		b.Config.UserFile = defaultUserFile
 	}
-	//Check if it's not already there
-	for _, id := range strings.Split(b.Config.Follow, ",") {
-		if id == user.Id_Str {
-			b.Output <- "Die volg ik al."
-			return false
-		}
+	source, err := newSource(b.Config, b.saveToken)
+	if err != nil {
+		log.Printf("Error in file %s: %s\n", "twitter.json", err)
+		return false, migrated
 	}
-	//Add the target
-	b.Config.Follow += "," + user.Id_Str
-	b.SaveConfig()
-	return true
+	b.source = source
+	return true, migrated
 }
-func (b *TwitterBot) DelTwit(twit string) bool {
-	user, success := b.userFromName(twit)
-	if !success {
-		b.Output <- "Die persoon ken ik niet."
-		return false
-	}
-	//If we're not following that person, quit
-	if strings.Index(b.Config.Follow, user.Id_Str) < 0 {
-		b.Output <- "Die persoon volg ik niet."
-		return false
-	}
-	//Make new list of followers, omitting the target
-	follows := []string{}
-	for _, uid := range strings.Split(b.Config.Follow, ",") {
-		if uid != user.Id_Str {
-			follows = append(follows, uid)
-		}
-	}
-	//Save changes
-	b.Config.Follow = strings.Join(follows, ",")
+
+//Keep a user token that was refreshed, for after a restart
+func (b *TwitterBot) saveToken(token OAuth2Token) {
+	b.lock.Lock()
+	b.Config.OAuth2 = &token
+	b.lock.Unlock()
 	b.SaveConfig()
-	return true
-}
-func (b *TwitterBot) ListTwits() {
-	users, success := b.usersFromNumbers(b.Config.Follow)
-	if !success {
-		return
-	}
-	usernames := make([]string, len(users))
-	for i := range users {
-		usernames[i] = "@" + users[i].Screen_Name
-	}
-	b.Output <- strings.Join(usernames, ", ")
 }
 
-func (b *TwitterBot) usersFromNumbers(numbers string) ([]User, bool) {
-	//Make oauth consumer
-	c := oauth.NewConsumer(
-		b.Config.CnsKey,
-		b.Config.CnsSecret,
-		oauth.ServiceProvider{
-			RequestTokenUrl:   "http://api.twitter.com/oauth/request_token",
-			AuthorizeTokenUrl: "https://api.twitter.com/oauth/authorize",
-			AccessTokenUrl:    "https://api.twitter.com/oauth/access_token",
-		})
-	//Request list of users
-	response, err := c.Post(
-		"https://api.twitter.com/1.1/users/lookup.json",
-		map[string]string{"user_id": numbers}, b.Config.AccessToken)
-	if err != nil {
-		log.Println("twb: Can't lookup users,", err)
-		return nil, false
-	}
-	jsonBlob, _ := ioutil.ReadAll(response.Body)
-	response.Body.Close()
-
-	var users []User
-	jErr := json.Unmarshal(jsonBlob, &users)
-	if jErr != nil {
-		log.Println("twb: Can't parse users,", jErr)
-		return nil, false
-	}
-	return users, true
-}
-func (b *TwitterBot) userFromName(name string) (User, bool) {
-	//Make oauth consumer
-	c := oauth.NewConsumer(
-		b.Config.CnsKey,
-		b.Config.CnsSecret,
-		oauth.ServiceProvider{
-			RequestTokenUrl:   "http://api.twitter.com/oauth/request_token",
-			AuthorizeTokenUrl: "https://api.twitter.com/oauth/authorize",
-			AccessTokenUrl:    "https://api.twitter.com/oauth/access_token",
-		})
-	//Request list of users
-	response, err := c.Get(
-		"https://api.twitter.com/1.1/users/show.json",
-		map[string]string{"screen_name": name}, b.Config.AccessToken)
-	if err != nil {
-		log.Println("twb: Can't lookup user,", err)
-		return User{Name: "", Screen_Name: "", Id_Str: ""}, false
-	}
-	jsonBlob, _ := ioutil.ReadAll(response.Body)
-	response.Body.Close()
-
-	var user User
-	jErr := json.Unmarshal(jsonBlob, &user)
-	if jErr != nil {
-		log.Println("twb: Can't parse user,", jErr)
-		return User{Name: "", Screen_Name: "", Id_Str: ""}, false
+func (b *TwitterBot) SaveConfig() bool {
+	b.lock.Lock()
+	jsonBlob, jsonErr := json.Marshal(b.Config)
+	b.lock.Unlock()
+	if jsonErr != nil {
+		log.Println("Error converting to JSON:", jsonErr)
+		return false
 	}
-	return user, true
+	return ioutil.WriteFile("twitter.json", jsonBlob, 0644) == nil
 }
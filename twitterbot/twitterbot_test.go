@@ -0,0 +1,1024 @@
+package twitterbot
+
+import (
+	"../catalog"
+	"./faketwitter"
+	"encoding/json"
+	"fmt"
+	"github.com/mrjones/oauth"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A source that the test can write tweets to. Every time the bot connects,
+// the writing end of the new connection is sent on Conns.
+type fakeSource struct {
+	twitterSource
+	Conns   chan *io.PipeWriter
+	Follows chan string
+	Users   map[string]User
+	// Connecting fails with these errors first
+	Errs chan error
+}
+
+func (s *fakeSource) Connect(follow []string) (ItemStream, error) {
+	select {
+	case err := <-s.Errs:
+		return nil, err
+	default:
+	}
+	r, w := io.Pipe()
+	s.Follows <- strings.Join(follow, ",")
+	s.Conns <- w
+	return newTweetStream(r), nil
+}
+
+func (s *fakeSource) LookupUser(name string) (User, error) {
+	user, ok := s.Users[name]
+	if !ok {
+		return user, fmt.Errorf("no user %s", name)
+	}
+	return user, nil
+}
+
+func (s *fakeSource) LookupUsers(ids []string) ([]User, error) {
+	var users []User
+	for _, id := range ids {
+		for _, user := range s.Users {
+			if user.Id_Str == id {
+				users = append(users, user)
+			}
+		}
+	}
+	return users, nil
+}
+
+func initFakeBot() (*TwitterBot, *fakeSource) {
+	b := newBot(make(chan Message), make(chan Request), catalog.New(), catalog.DefaultLanguage, "!")
+	b.Config.Follow = followList("12", "34")
+	source := &fakeSource{
+		twitterSource: *newTwitterSource(b.Config, nil),
+		Conns:         make(chan *io.PipeWriter, 1),
+		Follows:       make(chan string, 1),
+		Errs:          make(chan error, 10),
+		Users: map[string]User{
+			"erik":  {Screen_Name: "erik", Id_Str: "12"},
+			"harm":  {Screen_Name: "harm", Id_Str: "34"},
+			"ineke": {Screen_Name: "ineke", Id_Str: "56"},
+		},
+	}
+	b.source = source
+	return b, source
+}
+
+func followList(ids ...string) FollowList {
+	var list FollowList
+	for _, id := range ids {
+		list = append(list, &Followed{Id: id})
+	}
+	return list
+}
+
+var userIds = map[string]string{"erik": "12", "harm": "34", "ineke": "56"}
+
+func tweetLine(id int, user, text string) string {
+	return fmt.Sprintf(`{"id_str": "%d", "text": "%s", "user": {"screen_name": "%s", "id_str": "%s"}}`+"\r\n",
+		id, text, user, userIds[user])
+}
+
+// Ask for something and collect the answer
+func (b *TwitterBot) ask(command Command, arg string) []string {
+	return b.request(Request{Command: command, Arg: arg})
+}
+
+func (b *TwitterBot) request(r Request) []string {
+	reply := make(chan string)
+	r.Reply = reply
+	go func() {
+		b.HandleRequest(r)
+		close(reply)
+	}()
+	var lines []string
+	for line := range reply {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestStream(test *testing.T) {
+	b, stream := initFakeBot()
+	go b.ReadContinuous()
+	if follow := <-stream.Follows; follow != "12,34" {
+		test.Error("Connected following", follow)
+	}
+	w := <-stream.Conns
+
+	// Meanwhile, others are busy with the history
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			b.ask(CTL_OUTPUT_LINK, "erik")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			b.CleanHistory()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		go w.Write([]byte(tweetLine(i, "erik", "Hallo…")))
+		if out := (<-b.Output).Text; out != "[@erik] Hallo..." {
+			test.Error("Failed tweet with", out)
+		}
+	}
+	wg.Wait()
+
+	if lines := b.ask(CTL_OUTPUT_LINK, "eri"); len(lines) != 1 || lines[0] != "https://twitter.com/erik/status/19" {
+		test.Error("Failed link with", lines)
+	}
+	if lines := b.ask(CTL_OUTPUT_LINK, "harm"); len(lines) != 1 || lines[0] != catalog.New().Text("nl", "twitter.link.unknown", nil) {
+		test.Error("Failed link to unknown user with", lines)
+	}
+
+	// A reset closes the stream, and the reader connects again
+	b.ask(CTL_RECONNECT, "")
+	<-stream.Follows
+	w = <-stream.Conns
+	go w.Write([]byte(tweetLine(20, "harm", "Weer verbonden")))
+	if out := (<-b.Output).Text; out != "[@harm] Weer verbonden" {
+		test.Error("Failed tweet after reconnecting with", out)
+	}
+}
+
+// Run the test in a directory of its own, as the bot saves twitter.json in
+// the working directory
+func inTempDir() func() {
+	dir, _ := ioutil.TempDir("", "twitterbot")
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFollow(test *testing.T) {
+	b, _ := initFakeBot()
+	defer inTempDir()()
+
+	if lines := b.ask(CTL_ADD_USER, "ineke"); len(lines) != 0 {
+		test.Error("Failed follow with", lines)
+	}
+	if lines := b.ask(CTL_ADD_USER, "harm"); len(lines) != 1 || lines[0] != b.text("twitter.following") {
+		test.Error("Followed twice with", lines)
+	}
+	if lines := b.ask(CTL_ADD_USER, "niemand"); len(lines) != 1 || lines[0] != b.text("twitter.unknown") {
+		test.Error("Followed unknown user with", lines)
+	}
+	b.ask(CTL_DEL_USER, "erik")
+	if lines := b.ask(CTL_LIST_USERS, ""); len(lines) != 1 || lines[0] != "@harm, @ineke" {
+		test.Error("Failed list with", lines)
+	}
+}
+
+func TestMastodon(test *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/streaming/hashtag" || r.FormValue("tag") != "janeppo" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, ":thump\n\n")
+		fmt.Fprint(w, "event: delete\ndata: 1\n\n")
+		fmt.Fprint(w, "event: update\n"+
+			`data: {"id": "2", "content": "<p>Niet</p>", "account": {"id": "99", "acct": "harm"}}`+"\n\n")
+		fmt.Fprint(w, "event: update\n"+
+			`data: {"id": "3", "content": "<p>Hallo<br>daar &amp; <a href=\"x\">hier</a></p>",`+"\n"+
+			`data:  "account": {"id": "12", "acct": "erik@example.com"}}`+"\n\n")
+	}))
+	defer server.Close()
+
+	source := newMastodonSource(MastodonConfig{Server: server.URL + "/", Hashtag: "janeppo"})
+	stream, err := source.Connect([]string{"12"})
+	if err != nil {
+		test.Fatal("Failed to connect,", err)
+	}
+	defer stream.Close()
+	var item *Item
+	for item == nil {
+		if item, err = stream.Next(); err != nil {
+			test.Fatal("Failed to read,", err)
+		}
+	}
+	if !item.Deleted || item.Id != "1" {
+		test.Errorf("Read wrong delete %+v", item)
+	}
+	for item = nil; item == nil; {
+		if item, err = stream.Next(); err != nil {
+			test.Fatal("Failed to read,", err)
+		}
+	}
+	if item.User.Screen_Name != "erik@example.com" || item.Text != "Hallo daar & hier" {
+		test.Errorf("Read wrong toot %+v", item)
+	}
+	if link := source.Permalink(item); link != server.URL+"/@erik@example.com/3" {
+		test.Error("Wrong link", link)
+	}
+}
+
+func TestBackoff(test *testing.T) {
+	var bo backoff
+	network := fmt.Errorf("connection refused")
+	unavailable := &StatusError{503, "503 Service Unavailable"}
+	calm := &StatusError{420, "420 Enhance Your Calm"}
+	errs := []error{network, network, unavailable, unavailable, unavailable, calm, calm, calm, calm, calm, calm}
+	want := []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+		20 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 16 * time.Minute}
+	for i, err := range errs {
+		if wait := bo.fail(err); wait != want[i] {
+			test.Errorf("Failure %d (%s) waits %s instead of %s", i+1, err, wait, want[i])
+		}
+	}
+	for i := 0; i < 100; i++ {
+		bo.fail(network)
+	}
+	if wait := bo.fail(network); wait != 16*time.Second {
+		test.Error("Network errors wait too long,", wait)
+	}
+	bo.reset()
+	if wait := bo.fail(unavailable); wait != 5*time.Second {
+		test.Error("Failed reset, waiting", wait)
+	}
+}
+
+func TestReconnect(test *testing.T) {
+	b, source := initFakeBot()
+	// Each wait is reported, and lasts until the test says so
+	waits := make(chan time.Duration)
+	resume := make(chan bool)
+	b.sleep = func(d time.Duration, wake <-chan bool) {
+		waits <- d
+		<-resume
+	}
+	expectWait := func(want time.Duration, what string) {
+		if wait := <-waits; wait != want {
+			test.Errorf("Failed %s with %s", what, wait)
+		}
+	}
+	source.Errs <- fmt.Errorf("connection refused")
+	source.Errs <- &StatusError{429, "429 Too Many Requests"}
+	if lines := b.ask(CTL_STATUS, ""); len(lines) != 1 || lines[0] != b.text("twitter.status.connecting") {
+		test.Error("Failed status before connecting with", lines)
+	}
+	go b.ReadContinuous()
+
+	// The channel hears about it once, and the bot waits instead of dying
+	if out := (<-b.Output).Text; out != b.text("twitter.failed") {
+		test.Error("Failed announcing failure with", out)
+	}
+	expectWait(250*time.Millisecond, "network backoff")
+	resume <- true
+	expectWait(time.Minute, "rate limit backoff")
+	want := b.textWith("twitter.status.waiting", catalog.Params{"error": "429 Too Many Requests", "attempts": 2, "seconds": 60})
+	if lines := b.ask(CTL_STATUS, ""); len(lines) != 1 || lines[0] != want {
+		test.Error("Failed status while waiting with", lines)
+	}
+	resume <- true
+
+	<-source.Follows
+	w := <-source.Conns
+	go w.Write([]byte(tweetLine(1, "erik", "Daar ben ik weer")))
+	if out := (<-b.Output).Text; out != "[@erik] Daar ben ik weer" {
+		test.Error("Failed tweet after reconnecting with", out)
+	}
+	if lines := b.ask(CTL_STATUS, ""); len(lines) != 1 || !strings.HasPrefix(lines[0], "Ik luister al sinds") {
+		test.Error("Failed status while connected with", lines)
+	}
+
+	// A broken stream means waiting a little, but only a little, and the
+	// channel doesn't need to know
+	w.CloseWithError(fmt.Errorf("connection reset by peer"))
+	expectWait(250*time.Millisecond, "backoff after broken stream")
+	resume <- true
+	<-source.Follows
+	w = <-source.Conns
+
+	// Breaking before saying anything makes the wait longer
+	w.CloseWithError(fmt.Errorf("connection reset by peer"))
+	expectWait(500*time.Millisecond, "backoff after flapping stream")
+	resume <- true
+	<-source.Follows
+	<-source.Conns
+}
+
+func TestResetDuringWait(test *testing.T) {
+	b, source := initFakeBot()
+	waiting := make(chan time.Duration)
+	b.sleep = func(d time.Duration, wake <-chan bool) {
+		waiting <- d
+		sleepUnlessWoken(d, wake)
+	}
+	source.Errs <- &StatusError{429, "429 Too Many Requests"}
+	go b.ReadContinuous()
+	<-b.Output
+	if wait := <-waiting; wait != time.Minute {
+		test.Error("Failed rate limit backoff with", wait)
+	}
+
+	// !fixtwitter doesn't have to wait out the minute
+	b.WantResetConnection()
+	select {
+	case <-source.Follows:
+	case <-time.After(5 * time.Second):
+		test.Fatal("A reset didn't end the wait")
+	}
+	<-source.Conns
+}
+
+func TestStreamMessages(test *testing.T) {
+	lines := []string{
+		`{"limit": {"track": 3}}`,
+		`{"warning": {"code": "FALLING_BEHIND", "message": "Te traag", "percent_full": 60}}`,
+		`{"delete": {"status": {"id": 1, "id_str": "1", "user_id": 12, "user_id_str": "12"}}}`,
+		`{"id_str": "2", "text": "Kijk https://t.co/a en https://t.co/b", "user": {"screen_name": "erik"},` +
+			` "entities": {"urls": [{"url": "https://t.co/a", "expanded_url": "https://www.rug.nl/"}],` +
+			` "media": [{"url": "https://t.co/b", "expanded_url": "https://twitter.com/erik/status/2/photo/1"}]}}`,
+		`{"id_str": "3", "text": "Half`,
+		`{"disconnect": {"code": 6, "stream_name": "janeppo", "reason": "token revoked"}}`,
+	}
+	stream := newTweetStream(ioutil.NopCloser(strings.NewReader(strings.Join(lines, "\r\n") + "\r\n")))
+	var items []*Item
+	var err error
+	for err == nil {
+		var item *Item
+		if item, err = stream.Next(); item != nil {
+			items = append(items, item)
+		}
+	}
+	if len(items) != 2 {
+		test.Fatal("Read wrong items", items)
+	}
+	if !items[0].Deleted || items[0].Id != "1" || items[0].User.Id_Str != "12" {
+		test.Errorf("Read wrong delete %+v", items[0])
+	}
+	if items[1].Text != "Kijk https://www.rug.nl/ en https://twitter.com/erik/status/2/photo/1" {
+		test.Error("Failed expanding links with", items[1].Text)
+	}
+	if disconnect, ok := err.(*DisconnectError); !ok || disconnect.Code != 6 || !disconnect.Final() {
+		test.Error("Failed disconnect with", err)
+	}
+}
+
+func TestDeleteAndDisconnect(test *testing.T) {
+	b, source := initFakeBot()
+	go b.ReadContinuous()
+	<-source.Follows
+	w := <-source.Conns
+	go func() {
+		w.Write([]byte(tweetLine(1, "erik", "Oeps")))
+		w.Write([]byte(tweetLine(2, "harm", "Blijft staan")))
+		w.Write([]byte(`{"delete": {"status": {"id_str": "1", "user_id_str": "12"}}}` + "\r\n"))
+		w.Write([]byte(`{"delete": {"status": {"id_str": "99", "user_id_str": "12"}}}` + "\r\n"))
+		w.Write([]byte(`{"disconnect": {"code": 7, "reason": "admin logout"}}` + "\r\n"))
+	}()
+	<-b.Output
+	<-b.Output
+	if out := (<-b.Output).Text; out != b.textWith("twitter.deleted", catalog.Params{"user": "erik"}) {
+		test.Error("Failed delete with", out)
+	}
+	if lines := b.ask(CTL_OUTPUT_LINK, "erik"); len(lines) != 1 || lines[0] != b.text("twitter.link.unknown") {
+		test.Error("Deleted tweet still linked,", lines)
+	}
+
+	// Twitter doesn't want us back, so we wait for a reset
+	if out := (<-b.Output).Text; out != b.text("twitter.failed") {
+		test.Error("Failed final disconnect with", out)
+	}
+	if lines := b.ask(CTL_STATUS, ""); len(lines) != 1 || !strings.Contains(lines[0], "admin logout") {
+		test.Error("Failed status after disconnect with", lines)
+	}
+	select {
+	case <-source.Follows:
+		test.Error("Connected again after final disconnect")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.ask(CTL_RECONNECT, "")
+	<-source.Follows
+	w = <-source.Conns
+	go w.Write([]byte(tweetLine(3, "erik", "Terug")))
+	if out := (<-b.Output).Text; out != "[@erik] Terug" {
+		test.Error("Failed tweet after reset with", out)
+	}
+}
+
+func TestFilter(test *testing.T) {
+	defer inTempDir()()
+	b, source := initFakeBot()
+	go b.ReadContinuous()
+	<-source.Follows
+	w := <-source.Conns
+
+	erik := `{"screen_name": "erik", "id_str": "12"}`
+	harm := `{"screen_name": "harm", "id_str": "34"}`
+	piet := `{"screen_name": "piet", "id_str": "99"}`
+	tweets := map[string]string{
+		"own":      `{"id_str": "1", "text": "Koffie!", "user": ` + erik + `}`,
+		"thread":   `{"id_str": "2", "text": "En thee", "in_reply_to_user_id_str": "12", "user": ` + erik + `}`,
+		"reply":    `{"id_str": "3", "text": "@piet Nee", "in_reply_to_user_id_str": "99", "user": ` + erik + `}`,
+		"retweet":  `{"id_str": "4", "text": "RT @harm: Kort…", "user": ` + erik + `, "retweeted_status": {"id_str": "5", "text": "Kort…", "user": ` + harm + `, "extended_tweet": {"full_text": "Kort maar lang"}}}`,
+		"quote":    `{"id_str": "6", "text": "Zie hier", "user": ` + erik + `, "quoted_status": {"id_str": "7", "text": "Hallo", "user": ` + piet + `}}`,
+		"mention":  `{"id_str": "8", "text": "@erik Ja", "in_reply_to_user_id_str": "12", "user": ` + piet + `}`,
+		"stranger": `{"id_str": "9", "text": "Hoi", "user": ` + piet + `}`,
+	}
+	order := []string{"own", "thread", "reply", "retweet", "quote", "mention", "stranger"}
+	// Send all the tweets, and a marker to know when they're done
+	relayed := func() []string {
+		go func() {
+			for _, name := range order {
+				w.Write([]byte(tweets[name] + "\r\n"))
+			}
+			w.Write([]byte(tweetLine(10, "harm", "Klaar")))
+		}()
+		var lines []string
+		for out := (<-b.Output).Text; out != "[@harm] Klaar"; out = (<-b.Output).Text {
+			lines = append(lines, out)
+		}
+		return lines
+	}
+
+	want := []string{
+		"[@erik] Koffie!",
+		"[@erik] En thee",
+		"[@erik] @piet Nee",
+		"[@erik RT @harm] Kort maar lang",
+		"[@erik] Zie hier [QT @piet: Hallo]",
+	}
+	if lines := relayed(); strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		test.Error("Failed default filter with", lines)
+	}
+
+	no, yes := false, true
+	lines := b.ask(CTL_FILTER, "erik")
+	if len(lines) != 1 || lines[0] != "@erik: antwoorden aan, retweets aan, citaten aan, reacties van anderen uit." {
+		test.Error("Failed showing filter with", lines)
+	}
+	b.requestFilter("erik", &FilterChange{Replies: &no, Retweets: &no, Others: &yes})
+	lines = b.requestFilter("erik", &FilterChange{Exclude: "KOFFIE"})
+	if len(lines) != 1 || lines[0] != "@erik: antwoorden uit, retweets uit, citaten aan, reacties van anderen aan. Niet met: koffie." {
+		test.Error("Failed changing filter with", lines)
+	}
+	want = []string{
+		"[@erik] En thee",
+		"[@erik] Zie hier [QT @piet: Hallo]",
+		"[@piet] @erik Ja",
+	}
+	if lines := relayed(); strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		test.Error("Failed changed filter with", lines)
+	}
+
+	b.requestFilter("erik", &FilterChange{Clear: true, Include: "thee"})
+	if lines := relayed(); len(lines) != 1 || lines[0] != "[@erik] En thee" {
+		test.Error("Failed keyword filter with", lines)
+	}
+	if lines := b.ask(CTL_FILTER, "piet"); len(lines) != 1 || lines[0] != b.text("twitter.unknown") {
+		test.Error("Filtered unknown user with", lines)
+	}
+	if lines := b.ask(CTL_FILTER, "ineke"); len(lines) != 1 || lines[0] != b.text("twitter.not_following") {
+		test.Error("Filtered user we don't follow with", lines)
+	}
+
+	// The filters are saved, and what's missing is the default
+	saved := newBot(nil, nil, nil, "", "")
+	if !saved.ReadConfig() || saved.Config.Follow.find("12").Filter.Include[0] != "thee" || saved.Config.Follow.find("12").Filter.Replies {
+		test.Error("Failed to save filter")
+	}
+	if err := json.Unmarshal([]byte(`{"Follow": [{"Id": "34", "Filter": {"Retweets": false}}]}`), saved.Config); err != nil ||
+		!saved.Config.Follow.find("34").Filter.Quotes || saved.Config.Follow.find("34").Filter.Retweets {
+		test.Error("Failed filter defaults with", saved.Config.Follow.find("34").Filter, err)
+	}
+}
+
+func (b *TwitterBot) requestFilter(user string, change *FilterChange) []string {
+	return b.request(Request{Command: CTL_FILTER, Arg: user, Filter: change})
+}
+
+func TestHistory(test *testing.T) {
+	defer inTempDir()()
+	b, source := initFakeBot()
+	b.Config.HistoryFile = defaultHistoryFile
+	// Nothing to link to yet, so we're told how it works, the way the channel
+	// gives commands
+	lines := b.request(Request{Command: CTL_OUTPUT_LINK, Prefix: "."})
+	if len(lines) != 1 || !strings.Contains(lines[0], "'.link'") || strings.Contains(lines[0], "!link") {
+		test.Error("Failed help with", lines)
+	}
+	go b.ReadContinuous()
+	<-source.Follows
+	w := <-source.Conns
+	go func() {
+		w.Write([]byte(tweetLine(1, "erik", "Koffie?")))
+		w.Write([]byte(tweetLine(2, "harm", "Thee")))
+		w.Write([]byte(tweetLine(3, "erik", "Nee, thee")))
+		w.Write([]byte(tweetLine(4, "erik", "Of toch koffie")))
+		w.Write([]byte(`{"delete": {"status": {"id_str": "4", "user_id_str": "12"}}}` + "\r\n"))
+	}()
+	for i := 0; i < 5; i++ {
+		<-b.Output
+	}
+
+	// After a restart, we still know what was said
+	b, _ = initFakeBot()
+	b.Config.HistoryFile = defaultHistoryFile
+	b.LoadHistory()
+	if len(b.History) != 3 || b.History[2].Text != "Nee, thee" || b.History[2].Time.IsZero() {
+		test.Fatal("Failed to load history,", b.History)
+	}
+
+	link := func(id string) string {
+		return b.feed().Permalink(&Item{Id: id, User: User{Screen_Name: map[string]string{"1": "erik", "2": "harm", "3": "erik"}[id]}})
+	}
+	for query, want := range map[string]string{
+		"":        link("3"),
+		"erik":    link("3"),
+		"ERIK 2":  link("1"),
+		"erik 3":  b.text("twitter.link.few"),
+		"ar":      link("2"),
+		"koffie":  link("1"),
+		"thee 2":  link("2"),
+		"chocola": b.text("twitter.link.unknown"),
+		"2024":    b.text("twitter.link.unknown"),
+	} {
+		if lines := b.ask(CTL_OUTPUT_LINK, query); len(lines) != 1 || lines[0] != want {
+			test.Errorf("Failed link to %q with %s", query, lines)
+		}
+	}
+
+	lines = b.request(Request{Command: CTL_OUTPUT_TWEETS, Arg: "erik"})
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " [@erik] Koffie?") || !strings.HasSuffix(lines[1], " [@erik] Nee, thee") {
+		test.Error("Failed replay with", lines)
+	}
+	lines = b.request(Request{Command: CTL_OUTPUT_TWEETS, Arg: "erik", Count: 1})
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], " [@erik] Nee, thee") {
+		test.Error("Failed replay of one with", lines)
+	}
+
+	// Old things are forgotten, also on disk
+	b.History[0].Time = time.Now().Add(-historyAge - time.Hour)
+	b.CleanHistory()
+	b.LoadHistory()
+	if len(b.History) != 2 || b.History[0].Id != "2" {
+		test.Error("Failed cleaning history,", b.History)
+	}
+}
+
+func TestPost(test *testing.T) {
+	var lock sync.Mutex
+	var posted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Header.Get("Authorization") != "Bearer geheim" {
+			http.Error(w, "wie ben jij?", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/v1/statuses":
+			posted = append(posted, r.FormValue("status"))
+			fmt.Fprintf(w, `{"id": "%d", "content": "<p>%s</p>", "account": {"id": "1", "acct": "janeppo"}}`,
+				len(posted), r.FormValue("status"))
+		case r.Method == "DELETE" && r.URL.Path == fmt.Sprintf("/api/v1/statuses/%d", len(posted)):
+			posted = posted[:len(posted)-1]
+			fmt.Fprint(w, "{}")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	b, _ := initFakeBot()
+	b.source = newMastodonSource(MastodonConfig{Server: server.URL, Token: "geheim", Hashtag: "janeppo"})
+	texts := catalog.New()
+
+	if lines := b.request(Request{Command: CTL_PREVIEW_POST, Arg: "Hallo", Prefix: ".", Name: "toot"}); len(lines) != 1 ||
+		lines[0] != "Ik zou dit versturen: \"Hallo\" (5/500 tekens). Zeg '.toot ja' om het te versturen, of '.toot nee' als je je bedenkt." {
+		test.Error("Failed preview with", lines)
+	}
+	// On Mastodon we toot
+	for _, command := range []Command{CTL_PREVIEW_POST, CTL_POST} {
+		if lines := b.request(Request{Command: command, Arg: "Hallo", Prefix: ".", Name: "tweet"}); len(lines) != 1 ||
+			lines[0] != texts.Text("nl", "twitter.post.wrongcommand", catalog.Params{"prefix": ".", "command": "toot"}) {
+			test.Error("Failed tweeting on Mastodon with", lines)
+		}
+	}
+	long := strings.Repeat("é", 501)
+	for _, command := range []Command{CTL_PREVIEW_POST, CTL_POST} {
+		if lines := b.ask(command, long); len(lines) != 1 || !strings.Contains(lines[0], "501") {
+			test.Error("Failed long post with", lines)
+		}
+	}
+	if lines := b.ask(CTL_DELETE_POST, ""); len(lines) != 1 || lines[0] != texts.Text("nl", "twitter.post.nothing", nil) {
+		test.Error("Deleted nothing with", lines)
+	}
+
+	for i, text := range []string{"Eerste", "Tweede"} {
+		lines := b.ask(CTL_POST, text)
+		if len(lines) != 1 || !strings.HasSuffix(lines[0], fmt.Sprintf("%s/@janeppo/%d", server.URL, i+1)) {
+			test.Error("Failed post with", lines)
+		}
+	}
+	// Deleting takes back one at a time, last first
+	for _, want := range []string{"Eerste", ""} {
+		if lines := b.ask(CTL_DELETE_POST, ""); len(lines) != 1 || lines[0] != texts.Text("nl", "twitter.post.deleted", nil) {
+			test.Error("Failed delete with", lines)
+		}
+		lock.Lock()
+		if strings.Join(posted, "") != want {
+			test.Error("Left behind", posted)
+		}
+		lock.Unlock()
+	}
+
+	b.source = newMastodonSource(MastodonConfig{Server: server.URL, Token: "fout"})
+	if lines := b.ask(CTL_POST, "Hallo"); len(lines) != 1 || !strings.Contains(lines[0], "401") {
+		test.Error("Failed failing with", lines)
+	}
+	b.source = newBlueskySource(BlueskyConfig{})
+	if lines := b.ask(CTL_POST, "Hallo"); len(lines) != 1 || lines[0] != texts.Text("nl", "twitter.post.unsupported", nil) {
+		test.Error("Posted on bluesky with", lines)
+	}
+}
+
+// The bot against a fake Twitter, set up through twitter.json as it would be
+// for real
+func TestFakeTwitter(test *testing.T) {
+	defer inTempDir()()
+	fake := faketwitter.New("sleutel", "toegang")
+	fake.AddUser("erik", "12")
+	fake.AddUser("harm", "34")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	// Hang up, so Close doesn't wait for the stream. The bot waits a little
+	// before it tries again.
+	defer fake.Disconnect()
+
+	// Getting a token, as twittersetup does
+	consumer := oauth.NewConsumer("sleutel", "geheim", TwitterConfig{Api: server.URL}.ServiceProvider())
+	requestToken, _, err := consumer.GetRequestTokenAndUrl("oob")
+	if err != nil {
+		test.Fatal("Failed to get a request token,", err)
+	}
+	token, err := consumer.AuthorizeToken(requestToken, faketwitter.Verifier)
+	if err != nil || token.Token != "toegang" {
+		test.Fatal("Failed to get an access token,", token, err)
+	}
+
+	conf, _ := json.Marshal(Config{CnsKey: "sleutel", CnsSecret: "geheim", Follow: followList("12"), AccessToken: token,
+		Twitter: TwitterConfig{Stream: server.URL, Api: server.URL + "/"}})
+	ioutil.WriteFile("twitter.json", conf, 0644)
+	b := newBot(make(chan Message), make(chan Request), catalog.New(), catalog.DefaultLanguage, "!")
+	if !b.ReadConfig() {
+		test.Fatal("Failed to read config")
+	}
+	go b.ReadContinuous()
+	if follow := <-fake.Connects; follow != "12" {
+		test.Error("Connected following", follow)
+	}
+	fake.Tweet("erik", "Hallo")
+	if line := (<-b.Output).Text; line != "[@erik] Hallo" {
+		test.Error("Failed relaying with", line)
+	}
+
+	// Following someone more makes the bot reconnect
+	if lines := b.ask(CTL_ADD_USER, "harm"); len(lines) != 0 {
+		test.Error("Failed follow with", lines)
+	}
+	if follow := <-fake.Connects; follow != "12,34" {
+		test.Error("Reconnected following", follow)
+	}
+	if lines := b.ask(CTL_ADD_USER, "niemand"); len(lines) != 1 || lines[0] != b.text("twitter.unknown") {
+		test.Error("Followed nobody with", lines)
+	}
+	if lines := b.ask(CTL_LIST_USERS, ""); len(lines) != 1 || lines[0] != "@erik, @harm" {
+		test.Error("Failed list with", lines)
+	}
+	id := fake.Tweet("harm", "Koffie?")
+	if line := (<-b.Output).Text; line != "[@harm] Koffie?" {
+		test.Error("Failed relaying with", line)
+	}
+	if lines := b.ask(CTL_OUTPUT_LINK, "harm"); len(lines) != 1 || lines[0] != "https://twitter.com/harm/status/"+id {
+		test.Error("Failed link with", lines)
+	}
+
+	if lines := b.ask(CTL_DEL_USER, "erik"); len(lines) != 0 {
+		test.Error("Failed unfollow with", lines)
+	}
+	if follow := <-fake.Connects; follow != "34" {
+		test.Error("Reconnected following", follow)
+	}
+	fake.Tweet("erik", "Hoort iemand dit?")
+	fake.Tweet("harm", "Thee dan")
+	if line := (<-b.Output).Text; line != "[@harm] Thee dan" {
+		test.Error("Relayed someone unfollowed with", line)
+	}
+
+	// Posting, and taking it back
+	lines := b.ask(CTL_POST, "Hoi")
+	if len(lines) != 1 || !strings.Contains(lines[0], "https://twitter.com/janeppo/status/") {
+		test.Error("Failed post with", lines)
+	}
+	if lines := b.ask(CTL_DELETE_POST, ""); len(lines) != 1 || lines[0] != b.text("twitter.post.deleted") {
+		test.Error("Failed delete with", lines)
+	}
+
+	// When Twitter hangs up, we call again
+	fake.Disconnect()
+	if follow := <-fake.Connects; follow != "34" {
+		test.Error("Reconnected following", follow)
+	}
+}
+
+func TestUserCache(test *testing.T) {
+	defer inTempDir()()
+	fake := faketwitter.New("sleutel", "toegang")
+	var ids []string
+	for i := 0; i < 250; i++ {
+		ids = append(ids, fmt.Sprint(100+i))
+		fake.AddUser(fmt.Sprint("gebruiker", i), ids[i])
+	}
+	server := httptest.NewServer(fake)
+	b, _ := initFakeBot()
+	b.Config = &Config{CnsKey: "sleutel", AccessToken: &oauth.AccessToken{Token: "toegang"},
+		Twitter: TwitterConfig{Stream: server.URL, Api: server.URL}, UserFile: defaultUserFile}
+	b.source = newTwitterSource(b.Config, nil)
+
+	if lines := b.ask(CTL_LIST_USERS, ""); len(lines) != 1 || lines[0] != b.text("twitter.list.none") {
+		test.Error("Failed empty list with", lines)
+	}
+	// More than Twitter looks up at once, and more than fits on a line. The
+	// one that's gone is still followed, by id.
+	b.Config.Follow = followList(append(ids, "999")...)
+	lines := b.ask(CTL_LIST_USERS, "")
+	for _, line := range lines {
+		if len(line) > maxListLine {
+			test.Error("Too long a line,", line)
+		}
+	}
+	list := strings.Join(lines, ", ")
+	if len(lines) < 2 || !strings.HasPrefix(list, "@gebruiker0, @gebruiker1, ") || !strings.HasSuffix(list, ", @gebruiker249, 999") {
+		test.Error("Failed list with", lines)
+	}
+
+	// Without Twitter, we still know
+	server.Close()
+	if again := b.ask(CTL_LIST_USERS, ""); strings.Join(again, ", ") != list {
+		test.Error("Failed cached list with", again)
+	}
+	if lines := b.ask(CTL_ADD_USER, "Gebruiker3"); len(lines) != 1 || lines[0] != b.text("twitter.following") {
+		test.Error("Failed cached lookup with", lines)
+	}
+
+	// Also after a restart, until it's been too long
+	b, _ = initFakeBot()
+	b.Config = &Config{UserFile: defaultUserFile, AccessToken: &oauth.AccessToken{}, Twitter: TwitterConfig{Api: server.URL}}
+	b.source = newTwitterSource(b.Config, nil)
+	b.LoadUsers()
+	if user, err := b.lookupUser("gebruiker3"); err != nil || user.Id_Str != "103" {
+		test.Error("Failed lookup after restart with", user, err)
+	}
+	cached := b.users["103"]
+	cached.Time = time.Now().Add(-userTTL)
+	b.users["103"] = cached
+	if _, err := b.lookupUser("gebruiker3"); err == nil {
+		test.Error("Trusted an old name")
+	}
+}
+
+func TestPageList(test *testing.T) {
+	for _, c := range []struct {
+		items []string
+		want  []string
+	}{
+		{nil, nil},
+		{[]string{"aap", "noot", "mies"}, []string{"aap, noot", "mies"}},
+		{[]string{"aapnootmies", "wim"}, []string{"aapnootmies", "wim"}},
+	} {
+		if lines := pageList(c.items, 10); fmt.Sprint(lines) != fmt.Sprint(c.want) {
+			test.Errorf("Paged %v as %q", c.items, lines)
+		}
+	}
+}
+
+func TestFollowList(test *testing.T) {
+	defer inTempDir()()
+	// A twitter.json from before, with ids in a string and filters apart
+	ioutil.WriteFile("twitter.json", []byte(`{"Follow": "12,,34", "Filters": {"34": {"Retweets": false}}}`), 0644)
+	b, source := initFakeBot()
+	if !b.ReadConfig() {
+		test.Fatal("Failed to read old config")
+	}
+	b.source = source
+	if ids := b.following(); strings.Join(ids, ",") != "12,34" || b.Config.Follow.find("34").Filter.Retweets {
+		test.Error("Failed to migrate", ids, b.Config.Follow.find("34").Filter)
+	}
+	saved, _ := ioutil.ReadFile("twitter.json")
+	if !strings.Contains(string(saved), `"Follow":[{"Id":"12"`) || strings.Contains(string(saved), "Filters") {
+		test.Error("Failed to save migrated config,", string(saved))
+	}
+
+	// Whoever follows someone in a channel gets them there
+	b.request(Request{Command: CTL_ADD_USER, Arg: "ineke", Sender: "harm", Channels: []string{"#koffie"}})
+	if f := b.Config.Follow.find("56"); f == nil || f.Screen_Name != "ineke" || f.AddedBy != "harm" ||
+		f.AddedAt.IsZero() || strings.Join(f.Channels, ",") != "#koffie" {
+		test.Errorf("Failed follow with %+v", f)
+	}
+	go b.ReadContinuous()
+	if follow := <-source.Follows; follow != "12,34,56" {
+		test.Error("Connected following", follow)
+	}
+	w := <-source.Conns
+	go w.Write([]byte(tweetLine(1, "ineke", "Hoi")))
+	if out := <-b.Output; out != (Message{"#koffie", "[@ineke] Hoi"}) {
+		test.Error("Relayed to the wrong channel,", out)
+	}
+	go w.Write([]byte(tweetLine(2, "erik", "Hallo")))
+	if out := <-b.Output; out != (Message{"", "[@erik] Hallo"}) {
+		test.Error("Relayed to the wrong channel,", out)
+	}
+
+	// Names are filled in as they are looked up
+	if lines := b.ask(CTL_LIST_USERS, ""); len(lines) != 1 || lines[0] != "@erik, @harm, @ineke" {
+		test.Error("Failed list with", lines)
+	}
+	if f := b.Config.Follow.find("12"); f.Screen_Name != "erik" {
+		test.Error("Failed to remember name,", f.Screen_Name)
+	}
+}
+
+func TestOAuth2(test *testing.T) {
+	fake := faketwitter.New("sleutel", "toegang")
+	fake.AddUser("erik", "12")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	twitter := TwitterConfig{Stream: server.URL, Api: server.URL, Web: server.URL}
+
+	// A token for the app can look things up, but not post
+	app := &Config{CnsKey: "sleutel", CnsSecret: "geheim", Twitter: twitter}
+	token, err := AppToken(app)
+	if err != nil {
+		test.Fatal("Failed to get an app token,", err)
+	}
+	app.BearerToken = token
+	if user, err := VerifyCredentials(app); err != nil || user.Screen_Name != "" {
+		test.Error("Failed to verify the app with", user, err)
+	}
+	source := newTwitterSource(app, nil)
+	if user, err := source.LookupUser("erik"); err != nil || user.Id_Str != "12" {
+		test.Error("Failed lookup as the app with", user, err)
+	}
+	if _, err := source.Post("Hoi"); err == nil {
+		test.Error("Posted as the app")
+	}
+	if _, err := AppToken(&Config{CnsKey: "fout", Twitter: twitter}); err == nil {
+		test.Error("Got an app token with the wrong key")
+	}
+
+	// A token for the account, by way of the browser
+	conf := &Config{ClientId: "client", Twitter: twitter}
+	login, err := NewPKCE("http://127.0.0.1/callback")
+	if err != nil {
+		test.Fatal("Failed to start PKCE,", err)
+	}
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	response, err := browser.Get(login.AuthorizeUrl(conf))
+	if err != nil || response.StatusCode != http.StatusFound {
+		test.Fatal("Failed to authorize with", response, err)
+	}
+	response.Body.Close()
+	callback := response.Header.Get("Location")
+	if !strings.HasPrefix(callback, "http://127.0.0.1/callback?") {
+		test.Error("Sent back to", callback)
+	}
+	if _, err := (&PKCE{Verifier: "fout", State: login.State, RedirectUri: login.RedirectUri}).Exchange(conf, callback); err == nil {
+		test.Error("Got a token with the wrong verifier")
+	}
+	if _, err := login.Exchange(conf, strings.Replace(callback, login.State, "fout", 1)); err == nil {
+		test.Error("Got a token with the wrong state")
+	}
+	response, _ = browser.Get(login.AuthorizeUrl(conf))
+	response.Body.Close()
+	conf.OAuth2, err = login.Exchange(conf, response.Header.Get("Location"))
+	if err != nil || conf.OAuth2.RefreshToken == "" || conf.OAuth2.Expiry.Before(time.Now()) {
+		test.Fatal("Failed to get a user token with", conf.OAuth2, err)
+	}
+	if user, err := VerifyCredentials(conf); err != nil || user.Screen_Name != "janeppo" {
+		test.Error("Failed to verify the user with", user, err)
+	}
+
+	// When it runs out, it is refreshed, and whoever keeps it hears about it
+	var saved []OAuth2Token
+	source = newTwitterSource(conf, func(token OAuth2Token) { saved = append(saved, token) })
+	fake.ExpireTokens()
+	if _, err := source.Post("Hoi"); err != nil {
+		test.Error("Failed to post after expiry,", err)
+	}
+	if len(saved) != 1 || saved[0].AccessToken == conf.OAuth2.AccessToken {
+		test.Error("Saved tokens", saved)
+	}
+
+	// Until there's no way back
+	fake.Revoke()
+	if _, err := source.LookupUser("erik"); !needsSetup(err) {
+		test.Error("Failed lookup after revoking with", err)
+	}
+	if len(saved) != 1 {
+		test.Error("Saved tokens", saved)
+	}
+}
+
+func TestRevoked(test *testing.T) {
+	fake := faketwitter.New("sleutel", "toegang")
+	fake.AddUser("erik", "12")
+	fake.AddUser("harm", "34")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	defer fake.Disconnect()
+	b, _ := initFakeBot()
+	b.Config = &Config{CnsKey: "sleutel", AccessToken: &oauth.AccessToken{Token: "toegang"}, Follow: followList("12"),
+		Twitter: TwitterConfig{Stream: server.URL, Api: server.URL}}
+	b.source = newTwitterSource(b.Config, nil)
+	b.Prefix = "."
+	go b.ReadContinuous()
+	<-fake.Connects
+
+	// Twitter hangs up for good, and we say what to do about it, the way
+	// commands are given where we say it
+	fake.Revoke()
+	unauthorized := b.textWith("twitter.unauthorized", catalog.Params{"prefix": "."})
+	if line := (<-b.Output).Text; line != unauthorized || !strings.Contains(line, ".fixtwitter") {
+		test.Error("Failed revoking with", line)
+	}
+	if lines := b.request(Request{Command: CTL_ADD_USER, Arg: "harm", Prefix: "."}); len(lines) != 1 || lines[0] != unauthorized {
+		test.Error("Followed after revoking with", lines)
+	}
+
+	// Connecting doesn't get us in either, so we don't keep trying
+	b.WantResetConnection()
+	if line := (<-b.Output).Text; line != unauthorized {
+		test.Error("Failed connecting after revoking with", line)
+	}
+	if lines := b.request(Request{Command: CTL_STATUS, Prefix: "."}); len(lines) != 1 || !strings.Contains(lines[0], "401") ||
+		!strings.Contains(lines[0], ".fixtwitter") {
+		test.Error("Failed status with", lines)
+	}
+}
+
+func TestTwitterTimeout(test *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/1.1/statuses/filter.json" {
+			// A stream that is slow to say something is still a stream
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			fmt.Fprint(w, tweetLine(1, "erik", "Eindelijk"))
+			return
+		}
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+	timeout := restClient.Timeout
+	restClient.Timeout = 100 * time.Millisecond
+	defer func() { restClient.Timeout = timeout }()
+
+	twitter := TwitterConfig{Stream: server.URL, Api: server.URL}
+	for _, conf := range []*Config{
+		{CnsKey: "sleutel", AccessToken: &oauth.AccessToken{Token: "toegang"}, Twitter: twitter},
+		{BearerToken: "toegang", Twitter: twitter},
+	} {
+		source := newTwitterSource(conf, nil)
+		if _, err := source.LookupUser("erik"); err == nil {
+			test.Error("A hanging lookup didn't time out")
+		}
+		if _, err := source.Post("Hallo"); err == nil {
+			test.Error("A hanging post didn't time out")
+		}
+		stream, err := source.Connect([]string{"12"})
+		if err != nil {
+			test.Fatal("Failed connect with", err)
+		}
+		if item, err := stream.Next(); err != nil || item == nil || item.Text != "Eindelijk" {
+			test.Error("Failed slow stream with", item, err)
+		}
+		stream.Close()
+	}
+}
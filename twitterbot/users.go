@@ -0,0 +1,168 @@
+package twitterbot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUserFile = "users.json"
+	//How long we trust what we know of a user before asking again
+	userTTL = 7 * 24 * time.Hour
+	//How long a line of !following may get
+	maxListLine = 400
+)
+
+//A user as we last saw them
+type cachedUser struct {
+	User
+	Time time.Time
+}
+
+//Read what we knew about users before
+func (b *TwitterBot) LoadUsers() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	jsonBlob, err := ioutil.ReadFile(b.Config.UserFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("twb: Can't read users,", err)
+		}
+		return
+	}
+	var users map[string]cachedUser
+	if err := json.Unmarshal(jsonBlob, &users); err != nil {
+		log.Println("twb: Can't parse users,", err)
+		return
+	}
+	b.users = users
+}
+
+//Call with the lock held
+func (b *TwitterBot) saveUsers() {
+	if b.Config.UserFile == "" {
+		return
+	}
+	jsonBlob, err := json.Marshal(b.users)
+	if err == nil {
+		err = ioutil.WriteFile(b.Config.UserFile, jsonBlob, 0644)
+	}
+	if err != nil {
+		log.Println("twb: Can't save users,", err)
+	}
+}
+
+//Remember users we just heard about
+func (b *TwitterBot) cacheUsers(users []User) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.users == nil {
+		b.users = make(map[string]cachedUser)
+	}
+	now := time.Now()
+	for _, user := range users {
+		if user.Id_Str != "" {
+			b.users[user.Id_Str] = cachedUser{user, now}
+		}
+	}
+	b.saveUsers()
+}
+
+//Forget the users we asked for but didn't get, as they're gone
+func (b *TwitterBot) forgetUsers(asked []string, got []User) {
+	found := make(map[string]bool)
+	for _, user := range got {
+		found[user.Id_Str] = true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, id := range asked {
+		if !found[id] {
+			delete(b.users, id)
+		}
+	}
+	b.saveUsers()
+}
+
+//Look up a user by name, unless we know them well enough already
+func (b *TwitterBot) lookupUser(name string) (User, error) {
+	b.lock.Lock()
+	for _, cached := range b.users {
+		if strings.EqualFold(cached.Screen_Name, strings.TrimPrefix(name, "@")) && time.Since(cached.Time) < userTTL {
+			b.lock.Unlock()
+			return cached.User, nil
+		}
+	}
+	b.lock.Unlock()
+
+	user, err := b.feed().LookupUser(name)
+	if err != nil {
+		return user, err
+	}
+	b.cacheUsers([]User{user})
+	return user, nil
+}
+
+//The users with these ids, in that order. Only the ones we don't know well
+//enough are looked up. Users that aren't there any more are left out; if
+//the network can't be asked, we make do with what we knew, however old.
+func (b *TwitterBot) lookupUsers(ids []string) ([]User, error) {
+	var missing []string
+	b.lock.Lock()
+	for _, id := range ids {
+		if cached, ok := b.users[id]; !ok || time.Since(cached.Time) >= userTTL {
+			missing = append(missing, id)
+		}
+	}
+	b.lock.Unlock()
+
+	var lookupErr error
+	if len(missing) > 0 {
+		users, err := b.feed().LookupUsers(missing)
+		if err != nil {
+			log.Println("twb:", err)
+			lookupErr = err
+		}
+		b.cacheUsers(users)
+		if err == nil {
+			b.forgetUsers(missing, users)
+		}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	users := make([]User, 0, len(ids))
+	for _, id := range ids {
+		if cached, ok := b.users[id]; ok {
+			users = append(users, cached.User)
+		}
+	}
+	if len(users) == 0 && lookupErr != nil {
+		return nil, lookupErr
+	}
+	return users, nil
+}
+
+//Split a list into lines that fit on IRC
+func pageList(items []string, max int) []string {
+	var lines []string
+	line := ""
+	for _, item := range items {
+		if line != "" && len(line)+len(", ")+len(item) > max {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += ", "
+		}
+		line += item
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}